@@ -4,14 +4,12 @@ package action
 
 import (
 	"bytes"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
+	"io"
 	"strings"
 
 	"github.com/levenlabs/go-llog"
-	"github.com/levenlabs/thumper/config"
 	"github.com/levenlabs/thumper/context"
 	"github.com/mitchellh/mapstructure"
 )
@@ -25,6 +23,21 @@ type Actioner interface {
 	Do(context.Context) error
 }
 
+// Capturer is implemented by action types which can capture the response to
+// what they did, so that it can be made available to later actions and runs
+type Capturer interface {
+
+	// Captured returns the name the response should be kept under and the
+	// response, if one was captured by the last call to Do
+	Captured() (string, context.Response, bool)
+}
+
+// parser is implemented by action types which parse their templates ahead of
+// time, so that invalid ones are caught when the action is created
+type parser interface {
+	parse() error
+}
+
 // Action is a wrapper around an Actioner which contains some type information
 type Action struct {
 	Type string
@@ -52,6 +65,16 @@ func ToActioner(in interface{}) (Action, error) {
 		a = &PagerDuty{}
 	case "opsgenie":
 		a = &OpsGenie{}
+	case "slack":
+		a = &Slack{}
+	case "email":
+		a = &Email{}
+	case "teams":
+		a = &Teams{}
+	case "mattermost":
+		a = &Mattermost{}
+	case "discord":
+		a = &Discord{}
 	default:
 		return Action{}, fmt.Errorf("unknown action type: %q", typ)
 	}
@@ -59,9 +82,24 @@ func ToActioner(in interface{}) (Action, error) {
 	if err := mapstructure.Decode(min, a); err != nil {
 		return Action{}, err
 	}
+	if p, ok := a.(parser); ok {
+		if err := p.parse(); err != nil {
+			return Action{}, fmt.Errorf("parsing %s action: %s", typ, err)
+		}
+	}
 	return Action{Type: typ, Actioner: a}, nil
 }
 
+// executeTPL renders an already parsed text or html template against the
+// context
+func executeTPL(tpl interface {
+	Execute(io.Writer, interface{}) error
+}, c context.Context) (string, error) {
+	buf := new(bytes.Buffer)
+	err := tpl.Execute(buf, c)
+	return buf.String(), err
+}
+
 // Log is an action which does nothing but print a log message. Useful when
 // testing alerts and you don't want to set up any actions yet
 type Log struct {
@@ -73,174 +111,3 @@ func (l *Log) Do(_ context.Context) error {
 	llog.Info("doing log action", llog.KV{"message": l.Message})
 	return nil
 }
-
-// HTTP is an action which performs a single http request. If the request's
-// response doesn't have a 2xx response code then it's considered an error
-type HTTP struct {
-	Method  string            `mapstructure:"method"`
-	URL     string            `mapstructure:"url"`
-	Headers map[string]string `mapstructure:"headers"`
-	Body    string            `mapstructure:"body"`
-}
-
-// Do performs the actual http request. It doesn't need the alert context
-func (h *HTTP) Do(_ context.Context) error {
-	r, err := http.NewRequest(h.Method, h.URL, bytes.NewBufferString(h.Body))
-	if err != nil {
-		return err
-	}
-
-	if h.Headers != nil {
-		for k, v := range h.Headers {
-			r.Header.Set(k, v)
-		}
-	}
-
-	resp, err := http.DefaultClient.Do(r)
-	if err != nil {
-		return err
-	}
-	resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return fmt.Errorf("non 2xx response code returned: %d", resp.StatusCode)
-	}
-
-	return nil
-}
-
-// PagerDuty submits a trigger to a pagerduty endpoint
-type PagerDuty struct {
-	Key         string                 `mapstructure:"incident_key"`
-	Description string                 `mapstructure:"description"`
-	Details     map[string]interface{} `mapstructure:"details"`
-}
-
-// Do performs the actual trigger request to the pagerduty api
-func (p *PagerDuty) Do(c context.Context) error {
-	if config.PagerDutyKey == "" {
-		return errors.New("pagerduty key not set in config")
-	}
-	if p.Key == "" {
-		p.Key = c.Name
-	}
-
-	body := map[string]interface{}{
-		"service_key":  config.PagerDutyKey,
-		"event_type":   "trigger",
-		"description":  p.Description,
-		"incident_key": p.Key,
-		"details":      p.Details,
-	}
-	bodyb, err := json.Marshal(&body)
-	if err != nil {
-		return err
-	}
-
-	u := "https://events.pagerduty.com/generic/2010-04-15/create_event.json"
-	r, err := http.NewRequest("POST", u, bytes.NewBuffer(bodyb))
-	if err != nil {
-		return err
-	}
-	r.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(r)
-	if err != nil {
-		return err
-	}
-	resp.Body.Close()
-	return nil
-}
-
-type opsGenieResponder struct {
-	ID       string `json:"id,omitempty" mapstructure:"id"`
-	Name     string `json:"name,omitempty" mapstructure:"name"`
-	Username string `json:"username,omitempty" mapstructure:"username"`
-	Type     string `json:"type" mapstructure:"type"`
-}
-
-// OpsGenie submits an alert to an opsgenie endpoint
-type OpsGenie struct {
-	Message string `json:"message" mapstructure:"message"`
-	// Optional Params
-	Teams       []string               `json:"-" mapstructure:"teams"`
-	Alias       string                 `json:"alias" mapstructure:"alias"`
-	Description string                 `json:"description" mapstructure:"description"`
-	Recipients  []string               `json:"-" mapstructure:"recipients"`
-	Actions     []string               `json:"actions,omitempty" mapstructure:"actions"`
-	Source      string                 `json:"source" mapstructure:"source"`
-	Tags        []string               `json:"tags,omitempty" mapstructure:"tags"`
-	Details     map[string]interface{} `json:"details" mapstructure:"details"`
-	User        string                 `json:"user" mapstructure:"user"`
-	Note        string                 `json:"note" mapstructure:"note"`
-	Responders  []opsGenieResponder    `json:"responders,omitempty" mapstructure:"responders"`
-}
-
-// Do performs the actual alert request to the opsgenie api
-func (o *OpsGenie) Do(c context.Context) error {
-	if config.OpsGenieKey == "" {
-		return errors.New("opsgenie key not set in config")
-	}
-
-	if o.Alias == "" {
-		o.Alias = c.Name
-	}
-
-	if o.Message == "" {
-		return errors.New("missing required field messages in OpsGenie")
-	}
-
-	// convert teams/recipients to responders if none were given
-	if len(o.Responders) == 0 {
-		for _, r := range o.Recipients {
-			o.Responders = append(o.Responders, opsGenieResponder{
-				Username: r,
-				Type:     "user",
-			})
-		}
-		for _, t := range o.Teams {
-			o.Responders = append(o.Responders, opsGenieResponder{
-				Name: t,
-				Type: "team",
-			})
-		}
-	}
-
-	// convert all non-strings into strings
-	for k, d := range o.Details {
-		if sv, ok := d.(string); ok {
-			o.Details[k] = sv
-		} else {
-			o.Details[k] = fmt.Sprintf("%v", d)
-		}
-	}
-
-	bodyb, err := json.Marshal(&o)
-	if err != nil {
-		return err
-	}
-	u := "https://api.opsgenie.com/v2/alerts"
-	r, err := http.NewRequest("POST", u, bytes.NewBuffer(bodyb))
-	if err != nil {
-		return err
-	}
-	r.Header.Set("Content-Type", "application/json")
-	r.Header.Set("Authorization", fmt.Sprintf("GenieKey %s", config.OpsGenieKey))
-
-	resp, err := http.DefaultClient.Do(r)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	res := struct {
-		Message string `json:"message"`
-	}{}
-	// ignore error
-	json.NewDecoder(resp.Body).Decode(&res)
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		return fmt.Errorf("unexpected status code from opsgenie: %s. Message: %s", resp.Status, res.Message)
-	}
-	return nil
-}
@@ -0,0 +1,226 @@
+package action
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/levenlabs/thumper/context"
+)
+
+// The colors used for each severity, when no color is given explicitly
+var severityColors = map[string]string{
+	"critical": "#d9534f",
+	"error":    "#d9534f",
+	"warning":  "#f0ad4e",
+	"info":     "#5bc0de",
+	"ok":       "#5cb85c",
+}
+
+// ChatField is a single titled value shown in a chat message
+type ChatField struct {
+	Title string      `mapstructure:"title"`
+	Value interface{} `mapstructure:"value"`
+
+	// If set, the field may be shown side-by-side with other short fields
+	Short bool `mapstructure:"short"`
+}
+
+func (f ChatField) value() string {
+	if s, ok := f.Value.(string); ok {
+		return s
+	} else if f.Value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", f.Value)
+}
+
+// ChatMessage holds the fields shared by the actions which post a message to
+// a chat service's incoming webhook
+type ChatMessage struct {
+	WebhookURL string `mapstructure:"webhook_url"`
+
+	// At least one of Title and Message is required
+	Title   string      `mapstructure:"title"`
+	Message string      `mapstructure:"message"`
+	Fields  []ChatField `mapstructure:"fields"`
+
+	// One of critical, error, warning, info or ok. Determines the color of
+	// the message if no Color (e.g. "#ff0000") is given
+	Severity string `mapstructure:"severity"`
+	Color    string `mapstructure:"color"`
+
+	Timeout string `mapstructure:"timeout"` // Defaults to 30s
+}
+
+func (m ChatMessage) validate() error {
+	if m.WebhookURL == "" {
+		return errors.New("missing required field webhook_url")
+	} else if m.Title == "" && m.Message == "" {
+		return errors.New("one of title or message must be set")
+	} else if _, ok := severityColors[strings.ToLower(m.Severity)]; m.Severity != "" && !ok {
+		return fmt.Errorf("unknown severity: %q", m.Severity)
+	}
+	return nil
+}
+
+func (m ChatMessage) color() string {
+	if m.Color != "" {
+		return m.Color
+	}
+	return severityColors[strings.ToLower(m.Severity)]
+}
+
+// post posts the body to the webhook url as json. The response body is
+// included in the error if a non-2xx response code is returned, since chat
+// services generally describe what was wrong with the payload in it
+func (m ChatMessage) post(body interface{}) error {
+	cl, err := timeoutClient(m.Timeout)
+	if err != nil {
+		return err
+	}
+
+	bodyb, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := cl.Post(m.WebhookURL, "application/json", bytes.NewBuffer(bodyb))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("non 2xx response code returned: %d. Message: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Mattermost posts a message to a mattermost incoming webhook, as an
+// attachment so that it can have a color and fields
+type Mattermost struct {
+	ChatMessage `mapstructure:",squash"`
+
+	// Optional, override the webhook's defaults
+	Channel  string `mapstructure:"channel"`
+	Username string `mapstructure:"username"`
+	IconURL  string `mapstructure:"icon_url"`
+}
+
+type mattermostField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+type mattermostAttachment struct {
+	Fallback string            `json:"fallback"`
+	Color    string            `json:"color,omitempty"`
+	Title    string            `json:"title,omitempty"`
+	Text     string            `json:"text,omitempty"`
+	Fields   []mattermostField `json:"fields,omitempty"`
+}
+
+type mattermostPayload struct {
+	Channel     string                 `json:"channel,omitempty"`
+	Username    string                 `json:"username,omitempty"`
+	IconURL     string                 `json:"icon_url,omitempty"`
+	Attachments []mattermostAttachment `json:"attachments"`
+}
+
+// Do posts the Mattermost's message
+func (m *Mattermost) Do(_ context.Context) error {
+	if err := m.validate(); err != nil {
+		return fmt.Errorf("%s in Mattermost", err)
+	}
+
+	a := mattermostAttachment{
+		Fallback: m.Title,
+		Color:    m.color(),
+		Title:    m.Title,
+		Text:     m.Message,
+	}
+	if a.Fallback == "" {
+		a.Fallback = m.Message
+	}
+	for _, f := range m.Fields {
+		a.Fields = append(a.Fields, mattermostField{Title: f.Title, Value: f.value(), Short: f.Short})
+	}
+
+	return m.post(mattermostPayload{
+		Channel:     m.Channel,
+		Username:    m.Username,
+		IconURL:     m.IconURL,
+		Attachments: []mattermostAttachment{a},
+	})
+}
+
+// Discord posts a message to a discord webhook, as an embed so that it can
+// have a color and fields
+type Discord struct {
+	ChatMessage `mapstructure:",squash"`
+
+	// Optional, override the webhook's defaults
+	Username  string `mapstructure:"username"`
+	AvatarURL string `mapstructure:"avatar_url"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Color       *int           `json:"color,omitempty"`
+	Fields      []discordField `json:"fields,omitempty"`
+}
+
+type discordPayload struct {
+	Username  string         `json:"username,omitempty"`
+	AvatarURL string         `json:"avatar_url,omitempty"`
+	Embeds    []discordEmbed `json:"embeds"`
+}
+
+// Do posts the Discord's message
+func (d *Discord) Do(_ context.Context) error {
+	if err := d.validate(); err != nil {
+		return fmt.Errorf("%s in Discord", err)
+	}
+
+	e := discordEmbed{
+		Title:       d.Title,
+		Description: d.Message,
+	}
+	// discord takes colors as integers rather than hex strings
+	if c := strings.TrimPrefix(d.color(), "#"); c != "" {
+		color, err := strconv.ParseInt(c, 16, 32)
+		if err != nil || len(c) != 6 {
+			return fmt.Errorf("invalid color %q in Discord", d.Color)
+		}
+		ci := int(color)
+		e.Color = &ci
+	}
+	for _, f := range d.Fields {
+		// discord rejects fields with empty values
+		v := f.value()
+		if v == "" {
+			v = "-"
+		}
+		e.Fields = append(e.Fields, discordField{Name: f.Title, Value: v, Inline: f.Short})
+	}
+
+	return d.post(discordPayload{
+		Username:  d.Username,
+		AvatarURL: d.AvatarURL,
+		Embeds:    []discordEmbed{e},
+	})
+}
@@ -0,0 +1,151 @@
+package action
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	. "testing"
+
+	"github.com/levenlabs/thumper/context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookStub starts a server which records the json bodies posted to it. If a
+// body has a "bad" username it responds with a 400
+func webhookStub(t *T) (*httptest.Server, *[]map[string]interface{}) {
+	var bodies []map[string]interface{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+		if body["username"] == "bad" {
+			w.WriteHeader(400)
+			fmt.Fprint(w, "invalid payload")
+			return
+		}
+		bodies = append(bodies, body)
+		w.WriteHeader(204)
+	}))
+	return s, &bodies
+}
+
+var testChatFields = []interface{}{
+	map[string]interface{}{"title": "host", "value": "web1", "short": true},
+	map[string]interface{}{"title": "count", "value": 5},
+	map[string]interface{}{"title": "empty"},
+}
+
+func TestMattermostAction(t *T) {
+	s, bodies := webhookStub(t)
+	defer s.Close()
+
+	c := context.Context{Name: "foo"}
+	a, err := ToActioner(map[string]interface{}{
+		"type":        "mattermost",
+		"webhook_url": s.URL,
+		"channel":     "alerts",
+		"title":       "foo is broken",
+		"message":     "it matched 5 documents",
+		"severity":    "warning",
+		"fields":      testChatFields,
+	})
+	require.Nil(t, err)
+	require.Nil(t, a.Do(c))
+
+	m := &Mattermost{ChatMessage: ChatMessage{WebhookURL: s.URL, Message: "hi", Color: "#123456"}}
+	require.Nil(t, m.Do(c))
+
+	require.Len(t, *bodies, 2)
+	assert.Equal(t, map[string]interface{}{
+		"channel": "alerts",
+		"attachments": []interface{}{
+			map[string]interface{}{
+				"fallback": "foo is broken",
+				"color":    "#f0ad4e",
+				"title":    "foo is broken",
+				"text":     "it matched 5 documents",
+				"fields": []interface{}{
+					map[string]interface{}{"title": "host", "value": "web1", "short": true},
+					map[string]interface{}{"title": "count", "value": "5", "short": false},
+					map[string]interface{}{"title": "empty", "value": "", "short": false},
+				},
+			},
+		},
+	}, (*bodies)[0])
+	assert.Equal(t, map[string]interface{}{
+		"attachments": []interface{}{
+			map[string]interface{}{"fallback": "hi", "color": "#123456", "text": "hi"},
+		},
+	}, (*bodies)[1])
+
+	m = &Mattermost{ChatMessage: ChatMessage{WebhookURL: s.URL, Message: "hi"}, Username: "bad"}
+	err = m.Do(c)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid payload")
+
+	m = &Mattermost{ChatMessage: ChatMessage{WebhookURL: s.URL}}
+	assert.NotNil(t, m.Do(c))
+	m = &Mattermost{ChatMessage: ChatMessage{WebhookURL: s.URL, Message: "hi", Severity: "wat"}}
+	assert.NotNil(t, m.Do(c))
+}
+
+func TestDiscordAction(t *T) {
+	s, bodies := webhookStub(t)
+	defer s.Close()
+
+	c := context.Context{Name: "foo"}
+	a, err := ToActioner(map[string]interface{}{
+		"type":        "discord",
+		"webhook_url": s.URL,
+		"username":    "thumper",
+		"title":       "foo is broken",
+		"message":     "it matched 5 documents",
+		"severity":    "critical",
+		"fields":      testChatFields,
+	})
+	require.Nil(t, err)
+	require.Nil(t, a.Do(c))
+
+	require.Len(t, *bodies, 1)
+	assert.Equal(t, map[string]interface{}{
+		"username": "thumper",
+		"embeds": []interface{}{
+			map[string]interface{}{
+				"title":       "foo is broken",
+				"description": "it matched 5 documents",
+				"color":       float64(0xd9534f),
+				"fields": []interface{}{
+					map[string]interface{}{"name": "host", "value": "web1", "inline": true},
+					map[string]interface{}{"name": "count", "value": "5", "inline": false},
+					map[string]interface{}{"name": "empty", "value": "-", "inline": false},
+				},
+			},
+		},
+	}, (*bodies)[0])
+
+	d := &Discord{ChatMessage: ChatMessage{WebhookURL: s.URL, Message: "hi", Color: "red"}}
+	assert.NotNil(t, d.Do(c))
+	// black is sent rather than being taken as no color
+	d = &Discord{ChatMessage: ChatMessage{WebhookURL: s.URL, Message: "hi", Color: "#000000"}}
+	require.Nil(t, d.Do(c))
+	require.Len(t, *bodies, 2)
+	assert.Equal(t, float64(0), (*bodies)[1]["embeds"].([]interface{})[0].(map[string]interface{})["color"])
+
+	d = &Discord{ChatMessage: ChatMessage{WebhookURL: s.URL, Message: "hi", Color: "#fff"}}
+	assert.NotNil(t, d.Do(c))
+	d = &Discord{ChatMessage: ChatMessage{WebhookURL: s.URL, Message: "hi", Timeout: "wat"}}
+	assert.NotNil(t, d.Do(c))
+}
+
+func TestChatMessageTimeout(t *T) {
+	done := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer s.Close()
+	defer close(done)
+
+	m := ChatMessage{WebhookURL: s.URL, Timeout: "10ms"}
+	assert.NotNil(t, m.post(map[string]interface{}{"text": "hi"}))
+}
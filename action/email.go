@@ -0,0 +1,403 @@
+package action
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/levenlabs/thumper/config"
+	"github.com/levenlabs/thumper/context"
+	"github.com/levenlabs/thumper/search"
+)
+
+const defaultEmailTimeout = 30 * time.Second
+
+// Email sends an email through an smtp server. The subject and bodies are go
+// templates which are rendered against the alert's context, and the hits of
+// the alert's search can optionally be attached as a csv or json file
+type Email struct {
+	// host:port of the smtp server, defaults to the --smtp-addr param
+	Addr string `mapstructure:"addr"`
+
+	// One of "starttls" (STARTTLS is required), "tls" (implicit tls, usually
+	// on port 465) or "none". By default STARTTLS is used if the server
+	// supports it
+	TLS                string `mapstructure:"tls"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+
+	// Default to the --smtp-user and --smtp-password params. If there's a
+	// username then PLAIN auth is used
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+
+	// From defaults to the --smtp-from param. At least one address in To is
+	// required
+	From string   `mapstructure:"from"`
+	To   []string `mapstructure:"to"`
+	CC   []string `mapstructure:"cc"`
+
+	// Go templates rendered against the alert's context. At least one of Text
+	// and HTML is required, if both are given the email has both and the
+	// client picks which to show
+	Subject string `mapstructure:"subject"`
+	Text    string `mapstructure:"text"`
+	HTML    string `mapstructure:"html"`
+
+	// If set to "csv" or "json" then the hits of the alert's search are
+	// attached in that format. AttachSearch picks one of the alert's named
+	// searches to take the hits from instead
+	AttachHits   string `mapstructure:"attach_hits"`
+	AttachSearch string `mapstructure:"attach_search"`
+
+	Timeout string `mapstructure:"timeout"` // Defaults to 30s
+
+	subjectTPL, textTPL *template.Template
+	htmlTPL             *htmltemplate.Template
+}
+
+// parse parses the Email's templates, so that invalid ones are caught when the
+// action is created rather than each time it's performed
+func (e *Email) parse() error {
+	var err error
+	if e.subjectTPL, err = template.New("subject").Parse(e.Subject); err != nil {
+		return fmt.Errorf("subject: %s", err)
+	} else if e.textTPL, err = template.New("text").Parse(e.Text); err != nil {
+		return fmt.Errorf("text: %s", err)
+	} else if e.htmlTPL, err = htmltemplate.New("html").Parse(e.HTML); err != nil {
+		return fmt.Errorf("html: %s", err)
+	}
+	return nil
+}
+
+// Do renders the Email and sends it
+func (e *Email) Do(c context.Context) error {
+	addr, from := e.Addr, e.From
+	if addr == "" {
+		addr = config.SMTPAddr
+	}
+	if from == "" {
+		from = config.SMTPFrom
+	}
+	if addr == "" {
+		return errors.New("smtp addr not set in config or action")
+	} else if from == "" {
+		return errors.New("from address not set in config or action")
+	} else if len(e.To) == 0 {
+		return errors.New("missing required field to in Email")
+	} else if e.Text == "" && e.HTML == "" {
+		return errors.New("one of text or html must be set in Email")
+	}
+
+	if e.htmlTPL == nil {
+		if err := e.parse(); err != nil {
+			return err
+		}
+	}
+
+	timeout := defaultEmailTimeout
+	if e.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(e.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout: %s", err)
+		}
+	}
+
+	// the smtp envelope only takes bare addresses, while the headers may have
+	// names in them too
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return fmt.Errorf("invalid from address %q: %s", from, err)
+	}
+	var rcpts []string
+	for _, a := range append(append([]string{}, e.To...), e.CC...) {
+		rcpt, err := mail.ParseAddress(a)
+		if err != nil {
+			return fmt.Errorf("invalid recipient address %q: %s", a, err)
+		}
+		rcpts = append(rcpts, rcpt.Address)
+	}
+
+	msg, err := e.message(c, from)
+	if err != nil {
+		return err
+	}
+	return e.send(addr, fromAddr.Address, rcpts, msg, timeout)
+}
+
+// message renders the full email, headers included
+func (e *Email) message(c context.Context, from string) ([]byte, error) {
+	subject, err := executeTPL(e.subjectTPL, c)
+	if err != nil {
+		return nil, fmt.Errorf("subject: %s", err)
+	}
+	text, err := executeTPL(e.textTPL, c)
+	if err != nil {
+		return nil, fmt.Errorf("text: %s", err)
+	}
+	html, err := executeTPL(e.htmlTPL, c)
+	if err != nil {
+		return nil, fmt.Errorf("html: %s", err)
+	}
+
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+	fmt.Fprintf(buf, "From: %s\r\n", from)
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(e.To, ", "))
+	if len(e.CC) > 0 {
+		fmt.Fprintf(buf, "Cc: %s\r\n", strings.Join(e.CC, ", "))
+	}
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	if text != "" && html != "" {
+		alt := new(bytes.Buffer)
+		aw := multipart.NewWriter(alt)
+		if err := writeTextPart(aw, "text/plain", text); err != nil {
+			return nil, err
+		} else if err := writeTextPart(aw, "text/html", html); err != nil {
+			return nil, err
+		} else if err := aw.Close(); err != nil {
+			return nil, err
+		}
+
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"multipart/alternative; boundary=" + aw.Boundary()},
+		})
+		if err != nil {
+			return nil, err
+		} else if _, err := io.Copy(pw, alt); err != nil {
+			return nil, err
+		}
+	} else if text != "" {
+		if err := writeTextPart(mw, "text/plain", text); err != nil {
+			return nil, err
+		}
+	} else if err := writeTextPart(mw, "text/html", html); err != nil {
+		return nil, err
+	}
+
+	if e.AttachHits != "" {
+		if err := e.writeAttachment(mw, c); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeTextPart(mw *multipart.Writer, typ, body string) error {
+	pw, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {typ + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qw := quotedprintable.NewWriter(pw)
+	if _, err := qw.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+func (e *Email) writeAttachment(mw *multipart.Writer, c context.Context) error {
+	res := c.Result
+	if e.AttachSearch != "" {
+		var ok bool
+		if res, ok = c.Searches[e.AttachSearch]; !ok {
+			return fmt.Errorf("unknown search to attach hits from: %q", e.AttachSearch)
+		}
+	}
+
+	var typ, name string
+	var body []byte
+	switch strings.ToLower(e.AttachHits) {
+	case "csv":
+		typ, name = "text/csv", "hits.csv"
+		var err error
+		if body, err = hitsCSV(res.Hits); err != nil {
+			return err
+		}
+	case "json":
+		typ, name = "application/json", "hits.json"
+		var err error
+		if body, err = json.MarshalIndent(res.Hits, "", "  "); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown attach_hits format: %q", e.AttachHits)
+	}
+
+	pw, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {typ},
+		"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", name)},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+
+	// base64 bodies must be wrapped at 76 characters
+	enc := base64.StdEncoding.EncodeToString(body)
+	for len(enc) > 76 {
+		if _, err := io.WriteString(pw, enc[:76]+"\r\n"); err != nil {
+			return err
+		}
+		enc = enc[76:]
+	}
+	_, err = io.WriteString(pw, enc+"\r\n")
+	return err
+}
+
+// hitsCSV returns the hits as a csv, with a column for each of the hits'
+// metadata fields and then one for each field found in any of their sources.
+// Nested fields are flattened into dotted names
+func hitsCSV(hits []search.Hit) ([]byte, error) {
+	rows := make([]map[string]string, len(hits))
+	fieldsM := map[string]bool{}
+	for i, h := range hits {
+		rows[i] = map[string]string{}
+		flattenSource("", h.Source, rows[i])
+		for f := range rows[i] {
+			fieldsM[f] = true
+		}
+	}
+	fields := make([]string, 0, len(fieldsM))
+	for f := range fieldsM {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	buf := new(bytes.Buffer)
+	w := csv.NewWriter(buf)
+	w.Write(append([]string{"_index", "_id", "_score"}, fields...))
+	for i, h := range hits {
+		record := []string{h.Index, h.ID, strconv.FormatFloat(h.Score, 'f', -1, 64)}
+		for _, f := range fields {
+			record = append(record, rows[i][f])
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func flattenSource(prefix string, m map[string]interface{}, into map[string]string) {
+	for k, v := range m {
+		switch vv := v.(type) {
+		case map[string]interface{}:
+			flattenSource(prefix+k+".", vv, into)
+		case nil:
+			into[prefix+k] = ""
+		case string:
+			into[prefix+k] = vv
+		case float64:
+			into[prefix+k] = strconv.FormatFloat(vv, 'f', -1, 64)
+		case bool:
+			into[prefix+k] = strconv.FormatBool(vv)
+		default:
+			b, _ := json.Marshal(vv)
+			into[prefix+k] = string(b)
+		}
+	}
+}
+
+// send connects to the smtp server and sends the message to all recipients
+func (e *Email) send(addr, from string, rcpts []string, msg []byte, timeout time.Duration) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	tlsConf := &tls.Config{ServerName: host, InsecureSkipVerify: e.InsecureSkipVerify}
+
+	username, password := e.Username, e.Password
+	if username == "" {
+		username, password = config.SMTPUser, config.SMTPPassword
+	}
+
+	// PLAIN auth refuses to send credentials over an unencrypted connection,
+	// unless the server is on the same host
+	mode := strings.ToLower(e.TLS)
+	if mode == "none" && username != "" && host != "localhost" && host != "127.0.0.1" && host != "::1" {
+		return errors.New("smtp auth requires tls unless the server is on localhost, tls can't be none when there's a username")
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch mode {
+	case "tls":
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConf)
+	case "", "starttls", "none":
+		conn, err = dialer.Dial("tcp", addr)
+	default:
+		return fmt.Errorf("unknown email tls mode: %q", e.TLS)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	cl, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer cl.Close()
+
+	if mode == "" || mode == "starttls" {
+		if ok, _ := cl.Extension("STARTTLS"); ok {
+			if err := cl.StartTLS(tlsConf); err != nil {
+				return err
+			}
+		} else if mode == "starttls" {
+			return errors.New("smtp server doesn't support STARTTLS")
+		}
+	}
+
+	if username != "" {
+		if err := cl.Auth(smtp.PlainAuth("", username, password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := cl.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range rcpts {
+		if err := cl.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := cl.Data()
+	if err != nil {
+		return err
+	} else if _, err := w.Write(msg); err != nil {
+		return err
+	} else if err := w.Close(); err != nil {
+		return err
+	}
+	return cl.Quit()
+}
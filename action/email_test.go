@@ -0,0 +1,247 @@
+package action
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	. "testing"
+
+	"github.com/levenlabs/thumper/context"
+	"github.com/levenlabs/thumper/search"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smtpMail is what smtpStub received for a single email
+type smtpMail struct {
+	tls   bool
+	auth  string
+	from  string
+	rcpts []string
+	data  string
+}
+
+// smtpStub starts a minimal smtp server which accepts any email sent to it and
+// sends it on the returned channel. If tlsConf is set the server supports
+// STARTTLS, or if implicit is also set it only accepts tls connections
+func smtpStub(t *T, tlsConf *tls.Config, implicit bool) (string, <-chan smtpMail) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	if implicit {
+		l = tls.NewListener(l, tlsConf)
+	}
+	ch := make(chan smtpMail, 1)
+
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		m := smtpMail{tls: implicit}
+		r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+		reply := func(s string) {
+			w.WriteString(s + "\r\n")
+			w.Flush()
+		}
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch cmd {
+			case "EHLO":
+				if tlsConf != nil && !m.tls {
+					reply("250-localhost")
+					reply("250-STARTTLS")
+				} else {
+					reply("250-localhost")
+				}
+				reply("250 AUTH PLAIN")
+			case "STARTTLS":
+				reply("220 ready")
+				tconn := tls.Server(conn, tlsConf)
+				if tconn.Handshake() != nil {
+					return
+				}
+				conn, m.tls = tconn, true
+				r, w = bufio.NewReader(conn), bufio.NewWriter(conn)
+			case "AUTH":
+				m.auth = line
+				reply("235 ok")
+			case "MAIL":
+				m.from = line
+				reply("250 ok")
+			case "RCPT":
+				m.rcpts = append(m.rcpts, line)
+				reply("250 ok")
+			case "DATA":
+				reply("354 go ahead")
+				var data []string
+				for {
+					dl, err := r.ReadString('\n')
+					if err != nil {
+						return
+					} else if dl == ".\r\n" {
+						break
+					}
+					data = append(data, dl)
+				}
+				m.data = strings.Join(data, "")
+				reply("250 ok")
+			case "QUIT":
+				reply("221 bye")
+				ch <- m
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return l.Addr().String(), ch
+}
+
+func testTLSConfig() *tls.Config {
+	// piggyback on the certificate httptest generates
+	s := httptest.NewTLSServer(http.NotFoundHandler())
+	s.Close()
+	return s.TLS
+}
+
+func TestEmailAction(t *T) {
+	addr, ch := smtpStub(t, nil, false)
+	c := context.Context{Name: "foo"}
+	c.HitCount = 2
+	c.Hits = []search.Hit{
+		{Index: "logs", ID: "1", Score: 1.5, Source: map[string]interface{}{
+			"message": "bad thing",
+			"host":    map[string]interface{}{"name": "web1"},
+		}},
+		{Index: "logs", ID: "2", Score: 1, Source: map[string]interface{}{
+			"message": "other, bad thing",
+			"tags":    []interface{}{"a", "b"},
+		}},
+	}
+
+	a, err := ToActioner(map[string]interface{}{
+		"type":        "email",
+		"addr":        addr,
+		"username":    "user",
+		"password":    "pass",
+		"from":        "Thumper <thumper@example.com>",
+		"to":          []interface{}{"ops@example.com"},
+		"cc":          []interface{}{"Dev <dev@example.com>"},
+		"subject":     "{{.Name}} matched {{.HitCount}} documents",
+		"text":        "{{range .Hits}}{{.ID}}: {{index .Source \"message\"}}\n{{end}}",
+		"html":        "<b>{{.Name}}</b> <i>{{(index .Hits 0).Source.message}}</i>",
+		"attach_hits": "csv",
+	})
+	require.Nil(t, err)
+	require.Nil(t, a.Do(c))
+
+	m := <-ch
+	assert.False(t, m.tls)
+	assert.Equal(t, "AUTH PLAIN "+base64.StdEncoding.EncodeToString([]byte("\x00user\x00pass")), m.auth)
+	assert.Equal(t, "MAIL FROM:<thumper@example.com>", m.from)
+	assert.Equal(t, []string{"RCPT TO:<ops@example.com>", "RCPT TO:<dev@example.com>"}, m.rcpts)
+
+	msg, err := mail.ReadMessage(strings.NewReader(m.data))
+	require.Nil(t, err)
+	assert.Equal(t, "Thumper <thumper@example.com>", msg.Header.Get("From"))
+	assert.Equal(t, "ops@example.com", msg.Header.Get("To"))
+	assert.Equal(t, "Dev <dev@example.com>", msg.Header.Get("Cc"))
+	assert.Equal(t, "foo matched 2 documents", msg.Header.Get("Subject"))
+
+	typ, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.Nil(t, err)
+	assert.Equal(t, "multipart/mixed", typ)
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+
+	p, err := mr.NextPart()
+	require.Nil(t, err)
+	typ, params, err = mime.ParseMediaType(p.Header.Get("Content-Type"))
+	require.Nil(t, err)
+	assert.Equal(t, "multipart/alternative", typ)
+	ar := multipart.NewReader(p, params["boundary"])
+	ap, err := ar.NextPart()
+	require.Nil(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", ap.Header.Get("Content-Type"))
+	b, _ := ioutil.ReadAll(ap)
+	assert.Equal(t, "1: bad thing\r\n2: other, bad thing\r\n", string(b))
+	ap, err = ar.NextPart()
+	require.Nil(t, err)
+	assert.Equal(t, "text/html; charset=utf-8", ap.Header.Get("Content-Type"))
+	b, _ = ioutil.ReadAll(ap)
+	assert.Equal(t, "<b>foo</b> <i>bad thing</i>", string(b))
+
+	p, err = mr.NextPart()
+	require.Nil(t, err)
+	assert.Equal(t, "hits.csv", p.FileName())
+	b, _ = ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, p))
+	assert.Equal(t, "_index,_id,_score,host.name,message,tags\n"+
+		"logs,1,1.5,web1,bad thing,\n"+
+		"logs,2,1,,\"other, bad thing\",\"[\"\"a\"\",\"\"b\"\"]\"\n", string(b))
+}
+
+func TestEmailActionTLS(t *T) {
+	tlsConf := testTLSConfig()
+	for _, mode := range []string{"", "starttls", "tls"} {
+		addr, ch := smtpStub(t, tlsConf, mode == "tls")
+		e := &Email{
+			Addr:               addr,
+			TLS:                mode,
+			InsecureSkipVerify: true,
+			From:               "thumper@example.com",
+			To:                 []string{"ops@example.com"},
+			Subject:            "hi",
+			Text:               "hello",
+		}
+		require.Nil(t, e.Do(context.Context{}), "mode: %q", mode)
+		m := <-ch
+		assert.True(t, m.tls, "mode: %q", mode)
+
+		msg, err := mail.ReadMessage(strings.NewReader(m.data))
+		require.Nil(t, err)
+		_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		require.Nil(t, err)
+		p, err := multipart.NewReader(msg.Body, params["boundary"]).NextPart()
+		require.Nil(t, err)
+		assert.Equal(t, "text/plain; charset=utf-8", p.Header.Get("Content-Type"))
+	}
+
+	// a server without STARTTLS is fine unless it's required
+	addr, _ := smtpStub(t, nil, false)
+	e := &Email{Addr: addr, TLS: "starttls", From: "a@example.com", To: []string{"b@example.com"}, Text: "hi"}
+	assert.NotNil(t, e.Do(context.Context{}))
+
+	e = &Email{Addr: addr, From: "a@example.com", Text: "hi"}
+	assert.NotNil(t, e.Do(context.Context{}))
+	e = &Email{Addr: addr, From: "a@example.com", To: []string{"b@example.com"}, Text: "{{.Nope}}"}
+	assert.NotNil(t, e.Do(context.Context{}))
+
+	// templates which don't parse are caught when the action is created
+	_, err := ToActioner(map[string]interface{}{"type": "email", "to": []interface{}{"b@example.com"}, "text": "{{.Name"})
+	assert.NotNil(t, err)
+	e = &Email{Addr: addr, From: "a@example.com", To: []string{"b@example.com"}, Text: "hi", HTML: "{{"}
+	assert.NotNil(t, e.Do(context.Context{}))
+
+	// PLAIN auth won't be done without tls to anything but localhost
+	e = &Email{Addr: "smtp.example.com:25", TLS: "none", Username: "user", From: "a@example.com", To: []string{"b@example.com"}, Text: "hi"}
+	err = e.Do(context.Context{})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "tls can't be none")
+}
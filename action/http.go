@@ -0,0 +1,376 @@
+package action
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+
+	"github.com/levenlabs/thumper/context"
+)
+
+const (
+	defaultHTTPTimeout = 30 * time.Second
+
+	// At most this much of a response's body is captured
+	maxCaptureBody = 1 << 20
+)
+
+// HTTP is an action which performs a single http request. If the request's
+// response doesn't have a 2xx response code then it's considered an error.
+//
+// If Template is set, the url, headers, query params and body are go templates
+// which are rendered against the alert's context. The body may be given as a
+// string, or as JSON, a table which is encoded to json (with each of its string
+// values rendered if Template is set).
+type HTTP struct {
+	Method  string            `mapstructure:"method"` // Defaults to GET, or POST if JSON is set
+	URL     string            `mapstructure:"url"`
+	Headers map[string]string `mapstructure:"headers"`
+	Query   map[string]string `mapstructure:"query"` // Added to the url's query params
+	Body    string            `mapstructure:"body"`
+	JSON    interface{}       `mapstructure:"json"`
+
+	// Off by default, so that bodies containing {{ are sent as they are
+	Template bool `mapstructure:"template"`
+
+	// Basic auth is used if Username is set, bearer auth if BearerToken is
+	Username    string `mapstructure:"username"`
+	Password    string `mapstructure:"password"`
+	BearerToken string `mapstructure:"bearer_token"`
+
+	Timeout string `mapstructure:"timeout"` // Defaults to 30s
+
+	// CAFile is a pem file of certificates to verify the server's certificate
+	// against instead of the system's. CertFile and KeyFile are a client
+	// certificate to present to the server
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+	CAFile             string `mapstructure:"ca_file"`
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+
+	// If set, the response is captured under this name. It's available to
+	// later actions of the same run as .Responses.<name>, and to later runs
+	// as LastResponses.<name>. Responses with non-2xx response codes are
+	// captured too
+	Capture string `mapstructure:"capture"`
+
+	captured *context.Response
+
+	// Set by parse if the HTTP is templated. jsonTPL is a copy of JSON with
+	// its string values replaced by their templates
+	urlTPL, bodyTPL       *template.Template
+	headerTPLs, queryTPLs map[string]*template.Template
+	jsonTPL               interface{}
+}
+
+// parse parses the HTTP's templates, if it's templated, so that invalid ones
+// are caught when the action is created rather than each time it's performed
+func (h *HTTP) parse() error {
+	if !h.Template {
+		return nil
+	}
+
+	var err error
+	if h.urlTPL, err = template.New("url").Parse(h.URL); err != nil {
+		return fmt.Errorf("url: %s", err)
+	} else if h.bodyTPL, err = template.New("body").Parse(h.Body); err != nil {
+		return fmt.Errorf("body: %s", err)
+	} else if h.jsonTPL, err = parseJSON(h.JSON); err != nil {
+		return fmt.Errorf("json: %s", err)
+	}
+
+	h.headerTPLs = make(map[string]*template.Template, len(h.Headers))
+	for k, v := range h.Headers {
+		if h.headerTPLs[k], err = template.New("header").Parse(v); err != nil {
+			return fmt.Errorf("header %s: %s", k, err)
+		}
+	}
+	h.queryTPLs = make(map[string]*template.Template, len(h.Query))
+	for k, v := range h.Query {
+		if h.queryTPLs[k], err = template.New("query").Parse(v); err != nil {
+			return fmt.Errorf("query param %s: %s", k, err)
+		}
+	}
+	return nil
+}
+
+// Captured returns the name and response captured by the last call to Do, if
+// there was one
+func (h *HTTP) Captured() (string, context.Response, bool) {
+	if h.Capture == "" || h.captured == nil {
+		return "", context.Response{}, false
+	}
+	return h.Capture, *h.captured, true
+}
+
+// Do performs the actual http request
+func (h *HTTP) Do(c context.Context) error {
+	r, err := h.request(c)
+	if err != nil {
+		return err
+	}
+
+	cl, err := h.client()
+	if err != nil {
+		return err
+	}
+
+	resp, err := cl.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if h.Capture != "" {
+		res, err := captureResponse(resp)
+		if err != nil {
+			return err
+		}
+		h.captured = &res
+	} else {
+		// drain the body so the connection can be reused
+		io.Copy(ioutil.Discard, resp.Body)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("non 2xx response code returned: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// render returns the rendered template if the HTTP is templated, otherwise the
+// string is returned as is
+func (h *HTTP) render(tpl *template.Template, s string, c context.Context) (string, error) {
+	if !h.Template {
+		return s, nil
+	}
+	return executeTPL(tpl, c)
+}
+
+// request renders the HTTP's templates and builds the request from them
+func (h *HTTP) request(c context.Context) (*http.Request, error) {
+	if h.Template && h.urlTPL == nil {
+		if err := h.parse(); err != nil {
+			return nil, err
+		}
+	}
+
+	u, err := h.render(h.urlTPL, h.URL, c)
+	if err != nil {
+		return nil, fmt.Errorf("url: %s", err)
+	}
+	if len(h.Query) > 0 {
+		pu, err := url.Parse(u)
+		if err != nil {
+			return nil, err
+		}
+		q := pu.Query()
+		for k, v := range h.Query {
+			if v, err = h.render(h.queryTPLs[k], v, c); err != nil {
+				return nil, fmt.Errorf("query param %s: %s", k, err)
+			}
+			q.Set(k, v)
+		}
+		pu.RawQuery = q.Encode()
+		u = pu.String()
+	}
+
+	method := h.Method
+	var body []byte
+	if h.JSON != nil {
+		if h.Body != "" {
+			return nil, errors.New("only one of body and json may be set in HTTP")
+		}
+		j := h.JSON
+		if h.Template {
+			if j, err = renderJSON(h.jsonTPL, c); err != nil {
+				return nil, fmt.Errorf("json: %s", err)
+			}
+		}
+		if l, ok := j.([]interface{}); ok && len(l) == 0 {
+			// lua doesn't distinguish between an empty object and an empty
+			// array, and an empty object is the more likely body
+			j = map[string]interface{}{}
+		}
+		if body, err = json.Marshal(j); err != nil {
+			return nil, err
+		}
+		if method == "" {
+			method = "POST"
+		}
+	} else {
+		b, err := h.render(h.bodyTPL, h.Body, c)
+		if err != nil {
+			return nil, fmt.Errorf("body: %s", err)
+		}
+		body = []byte(b)
+	}
+
+	r, err := http.NewRequest(method, u, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if h.JSON != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range h.Headers {
+		if v, err = h.render(h.headerTPLs[k], v, c); err != nil {
+			return nil, fmt.Errorf("header %s: %s", k, err)
+		}
+		r.Header.Set(k, v)
+	}
+
+	if h.Username != "" {
+		r.SetBasicAuth(h.Username, h.Password)
+	} else if h.BearerToken != "" {
+		r.Header.Set("Authorization", "Bearer "+h.BearerToken)
+	}
+
+	return r, nil
+}
+
+// parseJSON returns a copy of the given json-like value with all of its string
+// values parsed as templates
+func parseJSON(i interface{}) (interface{}, error) {
+	switch ii := i.(type) {
+	case string:
+		return template.New("json").Parse(ii)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(ii))
+		for k, v := range ii {
+			var err error
+			if m[k], err = parseJSON(v); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		s := make([]interface{}, len(ii))
+		for j, v := range ii {
+			var err error
+			if s[j], err = parseJSON(v); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	default:
+		return i, nil
+	}
+}
+
+// renderJSON returns a copy of a value returned by parseJSON with all of its
+// templates rendered
+func renderJSON(i interface{}, c context.Context) (interface{}, error) {
+	switch ii := i.(type) {
+	case *template.Template:
+		return executeTPL(ii, c)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(ii))
+		for k, v := range ii {
+			var err error
+			if m[k], err = renderJSON(v, c); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		s := make([]interface{}, len(ii))
+		for j, v := range ii {
+			var err error
+			if s[j], err = renderJSON(v, c); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	default:
+		return i, nil
+	}
+}
+
+// client returns the http client to perform the request with, based on the
+// HTTP's timeout and tls options
+func (h *HTTP) client() (*http.Client, error) {
+	cl := &http.Client{Timeout: defaultHTTPTimeout}
+	if h.Timeout != "" {
+		var err error
+		if cl.Timeout, err = time.ParseDuration(h.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout: %s", err)
+		}
+	}
+
+	if !h.InsecureSkipVerify && h.CAFile == "" && h.CertFile == "" {
+		return cl, nil
+	}
+
+	tlsConf := &tls.Config{InsecureSkipVerify: h.InsecureSkipVerify}
+	if h.CAFile != "" {
+		pem, err := ioutil.ReadFile(h.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.RootCAs = x509.NewCertPool()
+		if !tlsConf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %q", h.CAFile)
+		}
+	}
+	if h.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(h.CertFile, h.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tlsConf
+	cl.Transport = t
+	return cl, nil
+}
+
+// timeoutClient returns an http client with the given timeout, or with
+// defaultHTTPTimeout if the timeout is empty
+func timeoutClient(timeout string) (*http.Client, error) {
+	cl := &http.Client{Timeout: defaultHTTPTimeout}
+	if timeout != "" {
+		var err error
+		if cl.Timeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout: %s", err)
+		}
+	}
+	return cl, nil
+}
+
+func captureResponse(resp *http.Response) (context.Response, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCaptureBody))
+	if err != nil {
+		return context.Response{}, err
+	}
+
+	res := context.Response{
+		StatusCode: resp.StatusCode,
+		Headers:    make(map[string]string, len(resp.Header)),
+		Body:       string(body),
+		TS:         uint64(time.Now().Unix()),
+	}
+	for k := range resp.Header {
+		res.Headers[k] = resp.Header.Get(k)
+	}
+
+	// ignore error, the body doesn't have to be json
+	var data interface{}
+	if json.Unmarshal(body, &data) == nil {
+		res.Data = data
+	}
+	return res, nil
+}
@@ -0,0 +1,183 @@
+package action
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	. "testing"
+
+	"github.com/levenlabs/thumper/context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPAction(t *T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/good", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	})
+	mux.HandleFunc("/bad", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+	})
+	s := httptest.NewServer(mux)
+
+	h := &HTTP{
+		Method: "GET",
+		URL:    s.URL + "/good",
+		Body:   "OHAI",
+	}
+	require.Nil(t, h.Do(context.Context{}))
+
+	h.URL = s.URL + "/bad"
+	require.NotNil(t, h.Do(context.Context{}))
+}
+
+func TestHTTPActionTemplated(t *T) {
+	var l sync.Mutex
+	var reqs []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		l.Lock()
+		defer l.Unlock()
+		reqs = append(reqs, fmt.Sprintf("%s %s %s %s %s",
+			r.Method, r.URL.RequestURI(), r.Header.Get("Authorization"), r.Header.Get("X-Alert"), body,
+		))
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(500)
+			fmt.Fprint(w, "oops")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(201)
+		fmt.Fprint(w, `{"id":"abc"}`)
+	}))
+	defer s.Close()
+
+	c := context.Context{Name: "foo"}
+	c.HitCount = 5
+	a, err := ToActioner(map[string]interface{}{
+		"type":         "http",
+		"url":          s.URL + "/alerts/{{.Name}}",
+		"query":        map[string]interface{}{"count": "{{.HitCount}}"},
+		"headers":      map[string]interface{}{"X-Alert": "{{.Name}}"},
+		"bearer_token": "abc",
+		"template":     true,
+		"json": map[string]interface{}{
+			"name":  "{{.Name}}",
+			"count": 5,
+			"tags":  []interface{}{"a", "{{.Name}}"},
+		},
+		"capture": "create",
+	})
+	require.Nil(t, err)
+	require.Nil(t, a.Do(c))
+
+	name, res, ok := a.Actioner.(Capturer).Captured()
+	require.True(t, ok)
+	assert.Equal(t, "create", name)
+	assert.Equal(t, 201, res.StatusCode)
+	assert.Equal(t, "application/json", res.Headers["Content-Type"])
+	assert.Equal(t, `{"id":"abc"}`, res.Body)
+	assert.Equal(t, map[string]interface{}{"id": "abc"}, res.Data)
+
+	// later actions can use the captured response in their templates
+	c.Responses = map[string]context.Response{name: res}
+	h := &HTTP{
+		Method:   "PUT",
+		URL:      s.URL + "/fail",
+		Body:     "{{.Responses.create.Data.id}}",
+		Template: true,
+		Username: "user",
+		Password: "pass",
+		Capture:  "update",
+	}
+	require.NotNil(t, h.Do(c))
+	_, res, ok = h.Captured()
+	require.True(t, ok)
+	assert.Equal(t, 500, res.StatusCode)
+	assert.Equal(t, "oops", res.Body)
+	assert.Nil(t, res.Data)
+
+	// bodies aren't rendered unless the action is templated, and an empty
+	// table is sent as an empty object
+	h = &HTTP{Method: "POST", URL: s.URL, Body: "{{not a template}}"}
+	require.Nil(t, h.Do(c))
+	h = &HTTP{URL: s.URL, JSON: []interface{}{}}
+	require.Nil(t, h.Do(c))
+
+	l.Lock()
+	got := append([]string{}, reqs...)
+	l.Unlock()
+	require.Len(t, got, 4)
+	var body map[string]interface{}
+	prefix := "POST /alerts/foo?count=5 Bearer abc foo "
+	require.True(t, len(got[0]) > len(prefix))
+	assert.Equal(t, prefix, got[0][:len(prefix)])
+	require.Nil(t, json.Unmarshal([]byte(got[0][len(prefix):]), &body))
+	assert.Equal(t, map[string]interface{}{
+		"name":  "foo",
+		"count": float64(5),
+		"tags":  []interface{}{"a", "foo"},
+	}, body)
+	assert.Equal(t, "PUT /fail Basic dXNlcjpwYXNz  abc", got[1])
+	assert.Equal(t, "POST /   {{not a template}}", got[2])
+	assert.Equal(t, "POST /   {}", got[3])
+
+	h = &HTTP{URL: s.URL + "/{{.Nope}}", Template: true}
+	assert.NotNil(t, h.Do(c))
+	_, _, ok = h.Captured()
+	assert.False(t, ok)
+
+	// invalid templates are caught when the action is created, but only if
+	// the action is templated
+	for _, in := range []map[string]interface{}{
+		{"url": s.URL + "/{{.Name"},
+		{"url": s.URL, "headers": map[string]interface{}{"X-Alert": "{{.Name"}},
+		{"url": s.URL, "query": map[string]interface{}{"q": "{{.Name"}},
+		{"url": s.URL, "body": "{{.Name"},
+		{"url": s.URL, "json": map[string]interface{}{"tags": []interface{}{"{{.Name"}}},
+	} {
+		in["type"] = "http"
+		_, err = ToActioner(in)
+		assert.Nil(t, err)
+		in["template"] = true
+		_, err = ToActioner(in)
+		assert.NotNil(t, err, "%v", in)
+	}
+
+	h = &HTTP{URL: s.URL, Body: "foo", JSON: map[string]interface{}{}}
+	assert.NotNil(t, h.Do(c))
+}
+
+func TestHTTPActionTLS(t *T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer s.Close()
+
+	// the server's certificate isn't trusted by the system
+	h := &HTTP{URL: s.URL}
+	assert.NotNil(t, h.Do(context.Context{}))
+
+	h = &HTTP{URL: s.URL, InsecureSkipVerify: true}
+	assert.Nil(t, h.Do(context.Context{}))
+
+	dir, err := ioutil.TempDir("", "thumper-http-action")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	require.Nil(t, ioutil.WriteFile(caFile, caPEM, 0644))
+
+	h = &HTTP{URL: s.URL, CAFile: caFile, Timeout: "5s"}
+	assert.Nil(t, h.Do(context.Context{}))
+
+	h = &HTTP{URL: s.URL, Timeout: "wat"}
+	assert.NotNil(t, h.Do(context.Context{}))
+}
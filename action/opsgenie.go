@@ -0,0 +1,283 @@
+package action
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/levenlabs/thumper/config"
+	"github.com/levenlabs/thumper/context"
+)
+
+// The opsgenie api's base url in each region, a variable so it can be changed
+// in tests
+var opsGenieURLs = map[string]string{
+	"us": "https://api.opsgenie.com",
+	"eu": "https://api.eu.opsgenie.com",
+}
+
+// How long to wait between checks of an async request's status, and how many
+// times to check before giving up
+var (
+	opsGeniePollInterval = 500 * time.Millisecond
+	opsGeniePollAttempts = 20
+)
+
+// The operations an OpsGenie action can perform
+const (
+	OpsGenieCreate      = "create"
+	OpsGenieClose       = "close"
+	OpsGenieAcknowledge = "acknowledge"
+	OpsGenieNote        = "note"
+	OpsGenieTags        = "tags"
+	OpsGenieHeartbeat   = "heartbeat"
+)
+
+type opsGenieResponder struct {
+	ID       string `json:"id,omitempty" mapstructure:"id"`
+	Name     string `json:"name,omitempty" mapstructure:"name"`
+	Username string `json:"username,omitempty" mapstructure:"username"`
+	Type     string `json:"type" mapstructure:"type"`
+}
+
+// OpsGenie performs an operation against the opsgenie api. By default it
+// creates an alert, but it may also close, acknowledge, add a note to or add
+// tags to the alert with the same Alias, or ping a heartbeat. Unless Wait is
+// false, operations which opsgenie processes asynchronously are checked until
+// they're known to have succeeded or failed
+type OpsGenie struct {
+	Message string `json:"message" mapstructure:"message"`
+	// Optional Params
+	Teams       []string               `json:"-" mapstructure:"teams"`
+	Alias       string                 `json:"alias" mapstructure:"alias"`
+	Description string                 `json:"description" mapstructure:"description"`
+	Recipients  []string               `json:"-" mapstructure:"recipients"`
+	Actions     []string               `json:"actions,omitempty" mapstructure:"actions"`
+	Source      string                 `json:"source" mapstructure:"source"`
+	Tags        []string               `json:"tags,omitempty" mapstructure:"tags"`
+	Details     map[string]interface{} `json:"details" mapstructure:"details"`
+	User        string                 `json:"user" mapstructure:"user"`
+	Note        string                 `json:"note" mapstructure:"note"`
+	Responders  []opsGenieResponder    `json:"responders,omitempty" mapstructure:"responders"`
+
+	// One of the OpsGenie operation constants, defaults to create
+	Operation string `json:"-" mapstructure:"operation"`
+
+	// The name of the heartbeat to ping, defaults to the alert's name
+	Heartbeat string `json:"-" mapstructure:"heartbeat"`
+
+	// Defaults to the --opsgenie-key param
+	APIKey string `json:"-" mapstructure:"api_key"`
+
+	// "us" or "eu", defaults to the --opsgenie-region param
+	Region string `json:"-" mapstructure:"region"`
+
+	// Unless set to false, Do doesn't return until opsgenie has processed the
+	// request, and returns an error if it couldn't be
+	Wait *bool `json:"-" mapstructure:"wait"`
+
+	Timeout string `json:"-" mapstructure:"timeout"` // Defaults to 30s, per request
+}
+
+// request describes the http request the OpsGenie's operation needs. If async
+// is true then opsgenie processes the request asynchronously
+func (o *OpsGenie) request(c context.Context) (method, path string, body interface{}, async bool, err error) {
+	aliasPath := func(sub string) string {
+		return fmt.Sprintf("/v2/alerts/%s/%s?identifierType=alias", url.PathEscape(o.Alias), sub)
+	}
+	update := map[string]interface{}{
+		"user":   o.User,
+		"source": o.Source,
+		"note":   o.Note,
+	}
+
+	switch strings.ToLower(o.Operation) {
+	case "", OpsGenieCreate:
+		if o.Message == "" {
+			return "", "", nil, false, errors.New("missing required field messages in OpsGenie")
+		}
+		o.prepareCreate()
+		return "POST", "/v2/alerts", o, true, nil
+	case OpsGenieClose:
+		return "POST", aliasPath("close"), update, true, nil
+	case OpsGenieAcknowledge:
+		return "POST", aliasPath("acknowledge"), update, true, nil
+	case OpsGenieNote:
+		if o.Note == "" {
+			return "", "", nil, false, errors.New("missing required field note in OpsGenie")
+		}
+		return "POST", aliasPath("notes"), update, true, nil
+	case OpsGenieTags:
+		if len(o.Tags) == 0 {
+			return "", "", nil, false, errors.New("missing required field tags in OpsGenie")
+		}
+		update["tags"] = o.Tags
+		return "POST", aliasPath("tags"), update, true, nil
+	case OpsGenieHeartbeat:
+		name := o.Heartbeat
+		if name == "" {
+			name = c.Name
+		}
+		return "GET", fmt.Sprintf("/v2/heartbeats/%s/ping", url.PathEscape(name)), nil, false, nil
+	default:
+		return "", "", nil, false, fmt.Errorf("unknown opsgenie operation: %q", o.Operation)
+	}
+}
+
+// prepareCreate fills in the fields of a create request which are derived
+// from other fields
+func (o *OpsGenie) prepareCreate() {
+	// convert teams/recipients to responders if none were given
+	if len(o.Responders) == 0 {
+		for _, r := range o.Recipients {
+			o.Responders = append(o.Responders, opsGenieResponder{
+				Username: r,
+				Type:     "user",
+			})
+		}
+		for _, t := range o.Teams {
+			o.Responders = append(o.Responders, opsGenieResponder{
+				Name: t,
+				Type: "team",
+			})
+		}
+	}
+
+	// convert all non-strings into strings
+	for k, d := range o.Details {
+		if sv, ok := d.(string); ok {
+			o.Details[k] = sv
+		} else {
+			o.Details[k] = fmt.Sprintf("%v", d)
+		}
+	}
+}
+
+// Do performs the OpsGenie's operation against the opsgenie api
+func (o *OpsGenie) Do(c context.Context) error {
+	key := o.APIKey
+	if key == "" {
+		key = config.OpsGenieKey
+	}
+	if key == "" {
+		return errors.New("opsgenie key not set in config or action")
+	}
+
+	region := o.Region
+	if region == "" {
+		region = config.OpsGenieRegion
+	}
+	baseURL, ok := opsGenieURLs[strings.ToLower(region)]
+	if !ok {
+		return fmt.Errorf("unknown opsgenie region: %q", region)
+	}
+
+	if o.Alias == "" {
+		o.Alias = c.Name
+	}
+
+	method, path, body, async, err := o.request(c)
+	if err != nil {
+		return err
+	}
+	cl, err := timeoutClient(o.Timeout)
+	if err != nil {
+		return err
+	}
+
+	var res struct {
+		RequestID string `json:"requestId"`
+	}
+	if err := opsGenieDo(cl, method, baseURL+path, key, body, &res); err != nil {
+		return err
+	}
+	if !async || (o.Wait != nil && !*o.Wait) {
+		return nil
+	}
+	return opsGenieWait(cl, baseURL, key, res.RequestID)
+}
+
+// opsGenieWait checks the status of an async request until it's been
+// processed, returning an error if it failed or wasn't processed in time
+func opsGenieWait(cl *http.Client, baseURL, key, requestID string) error {
+	if requestID == "" {
+		return errors.New("no request id returned by opsgenie")
+	}
+	u := fmt.Sprintf("%s/v2/alerts/requests/%s", baseURL, url.PathEscape(requestID))
+
+	for i := 0; i < opsGeniePollAttempts; i++ {
+		time.Sleep(opsGeniePollInterval)
+
+		var res struct {
+			Data struct {
+				Success bool   `json:"success"`
+				Status  string `json:"status"`
+			} `json:"data"`
+		}
+		err := opsGenieDo(cl, "GET", u, key, nil, &res)
+		if e, ok := err.(*opsGenieError); ok && e.code == http.StatusNotFound {
+			// the request hasn't been processed yet
+			continue
+		} else if err != nil {
+			return err
+		} else if !res.Data.Success {
+			return fmt.Errorf("opsgenie request failed: %s", res.Data.Status)
+		}
+		return nil
+	}
+	return fmt.Errorf("opsgenie request %s wasn't processed in time", requestID)
+}
+
+// opsGenieError is returned when the opsgenie api responds with an error
+type opsGenieError struct {
+	code    int
+	status  string
+	message string
+}
+
+func (e *opsGenieError) Error() string {
+	return fmt.Sprintf("unexpected status code from opsgenie: %s. Message: %s", e.status, e.message)
+}
+
+// opsGenieDo performs a single request against the opsgenie api, decoding the
+// response body into res
+func opsGenieDo(cl *http.Client, method, u, key string, body, res interface{}) error {
+	var bodyb []byte
+	if body != nil {
+		var err error
+		if bodyb, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	r, err := http.NewRequest(method, u, bytes.NewBuffer(bodyb))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", fmt.Sprintf("GenieKey %s", key))
+
+	resp, err := cl.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		errRes := struct {
+			Message string `json:"message"`
+		}{}
+		// ignore error
+		json.NewDecoder(resp.Body).Decode(&errRes)
+		return &opsGenieError{code: resp.StatusCode, status: resp.Status, message: errRes.Message}
+	}
+
+	// ignore error, the caller checks for the fields it needs
+	json.NewDecoder(resp.Body).Decode(res)
+	return nil
+}
@@ -0,0 +1,107 @@
+package action
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/levenlabs/thumper/context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpsGenieAction(t *T) {
+	var l sync.Mutex
+	var reqs []string
+	polls := map[string]int{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "GenieKey abc", r.Header.Get("Authorization"))
+		l.Lock()
+		defer l.Unlock()
+
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		reqs = append(reqs, fmt.Sprintf("%s %s %v", r.Method, r.URL.RequestURI(), body))
+
+		switch r.URL.Path {
+		case "/v2/alerts", "/v2/alerts/foo/close":
+			w.WriteHeader(202)
+			fmt.Fprintf(w, `{"result":"Request will be processed","requestId":"%s"}`, r.URL.Path)
+		case "/v2/alerts/foo/notes":
+			w.WriteHeader(202)
+			fmt.Fprint(w, `{"result":"Request will be processed","requestId":"bad"}`)
+		case "/v2/heartbeats/foo/ping":
+			w.WriteHeader(202)
+			fmt.Fprint(w, `{"result":"PONG - Heartbeat received"}`)
+		}
+	})
+	mux.HandleFunc("/v2/alerts/requests/", func(w http.ResponseWriter, r *http.Request) {
+		l.Lock()
+		defer l.Unlock()
+		id := r.URL.Path[len("/v2/alerts/requests/"):]
+		// the first check of each request finds it hasn't been processed yet
+		if polls[id]++; polls[id] == 1 {
+			w.WriteHeader(404)
+			fmt.Fprint(w, `{"message":"Request not found"}`)
+			return
+		}
+		if id == "bad" {
+			fmt.Fprint(w, `{"data":{"success":false,"status":"Alert does not exist"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"success":true,"status":"Processed"}}`)
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	oldURLs, oldInterval := opsGenieURLs, opsGeniePollInterval
+	defer func() { opsGenieURLs, opsGeniePollInterval = oldURLs, oldInterval }()
+	opsGenieURLs = map[string]string{"eu": s.URL}
+	opsGeniePollInterval = time.Millisecond
+
+	c := context.Context{Name: "foo"}
+	o := &OpsGenie{APIKey: "abc", Region: "EU", Message: "something broke", Teams: []string{"ops"}}
+	require.Nil(t, o.Do(c))
+
+	o = &OpsGenie{APIKey: "abc", Region: "eu", Operation: "close", Note: "recovered"}
+	require.Nil(t, o.Do(c))
+
+	o = &OpsGenie{APIKey: "abc", Region: "eu", Operation: "heartbeat"}
+	require.Nil(t, o.Do(c))
+
+	// with wait set to false the request isn't checked on
+	a, err := ToActioner(map[string]interface{}{
+		"type": "opsgenie", "api_key": "abc", "region": "eu", "operation": "note", "note": "hi", "wait": false,
+	})
+	require.Nil(t, err)
+	require.Nil(t, a.Do(c))
+	l.Lock()
+	assert.Equal(t, 0, polls["bad"])
+	l.Unlock()
+
+	o = &OpsGenie{APIKey: "abc", Region: "eu", Operation: "note", Note: "hi"}
+	err = o.Do(c)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Alert does not exist")
+
+	assert.Equal(t, []string{
+		"POST /v2/alerts map[alias:foo description: details:<nil> message:something broke note: responders:[map[name:ops type:team]] source: user:]",
+		"POST /v2/alerts/foo/close?identifierType=alias map[note:recovered source: user:]",
+		"GET /v2/heartbeats/foo/ping map[]",
+		"POST /v2/alerts/foo/notes?identifierType=alias map[note:hi source: user:]",
+		"POST /v2/alerts/foo/notes?identifierType=alias map[note:hi source: user:]",
+	}, reqs)
+	assert.Equal(t, map[string]int{"v2/alerts": 2, "v2/alerts/foo/close": 2, "bad": 2}, polls)
+
+	o = &OpsGenie{APIKey: "abc", Region: "eu", Operation: "wat"}
+	assert.NotNil(t, o.Do(c))
+	o = &OpsGenie{APIKey: "abc", Region: "mars"}
+	assert.NotNil(t, o.Do(c))
+	o = &OpsGenie{APIKey: "abc", Region: "eu", Timeout: "wat"}
+	assert.NotNil(t, o.Do(c))
+}
@@ -0,0 +1,205 @@
+package action
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/levenlabs/thumper/config"
+	"github.com/levenlabs/thumper/context"
+)
+
+// The pagerduty Events API v2 endpoint, a variable so it can be changed in
+// tests
+var pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerduty rejects summaries longer than this
+const pagerDutyMaxSummary = 1024
+
+// PagerDutyLink is a link attached to a pagerduty event
+type PagerDutyLink struct {
+	Href string `json:"href" mapstructure:"href"`
+	Text string `json:"text,omitempty" mapstructure:"text"`
+}
+
+// PagerDutyImage is an image attached to a pagerduty event
+type PagerDutyImage struct {
+	Src  string `json:"src" mapstructure:"src"`
+	Href string `json:"href,omitempty" mapstructure:"href"`
+	Alt  string `json:"alt,omitempty" mapstructure:"alt"`
+}
+
+// PagerDuty sends an event to pagerduty using the Events API v2. By default
+// the event triggers an incident, but it may also acknowledge or resolve the
+// incident with the same Key
+type PagerDuty struct {
+	// The dedup key of the event, defaults to the alert's name
+	Key string `mapstructure:"incident_key"`
+
+	// The summary of the event. While it's possible to use templated terms in
+	// here, it makes the most sense to have this be a static message and use
+	// Details for dynamic data
+	Description string                 `mapstructure:"description"`
+	Details     map[string]interface{} `mapstructure:"details"`
+
+	// Defaults to the --pagerduty-key param
+	RoutingKey string `mapstructure:"routing_key"`
+
+	// One of trigger (the default), acknowledge or resolve
+	EventAction string `mapstructure:"event_action"`
+
+	// One of critical, error (the default), warning or info
+	Severity string `mapstructure:"severity"`
+
+	// Where the problem is, defaults to the hostname thumper is running on
+	Source string `mapstructure:"source"`
+
+	Component string           `mapstructure:"component"`
+	Group     string           `mapstructure:"group"`
+	Class     string           `mapstructure:"class"`
+	Links     []PagerDutyLink  `mapstructure:"links"`
+	Images    []PagerDutyImage `mapstructure:"images"`
+
+	Timeout string `mapstructure:"timeout"` // Defaults to 30s
+}
+
+type pagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	Component     string                 `json:"component,omitempty"`
+	Group         string                 `json:"group,omitempty"`
+	Class         string                 `json:"class,omitempty"`
+	CustomDetails map[string]interface{} `json:"custom_details,omitempty"`
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Client      string            `json:"client,omitempty"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+	Links       []PagerDutyLink   `json:"links,omitempty"`
+	Images      []PagerDutyImage  `json:"images,omitempty"`
+}
+
+// event builds the request body for the PagerDuty
+func (p *PagerDuty) event(c context.Context) (pagerDutyEvent, error) {
+	e := pagerDutyEvent{
+		RoutingKey:  p.RoutingKey,
+		EventAction: strings.ToLower(p.EventAction),
+		DedupKey:    p.Key,
+	}
+	if e.RoutingKey == "" {
+		e.RoutingKey = config.PagerDutyKey
+	}
+	if e.RoutingKey == "" {
+		return e, errors.New("pagerduty key not set in config or action")
+	}
+	if e.EventAction == "" {
+		e.EventAction = "trigger"
+	}
+	if e.DedupKey == "" {
+		e.DedupKey = c.Name
+	}
+
+	switch e.EventAction {
+	case "acknowledge", "resolve":
+		// these only need to identify the incident
+		return e, nil
+	case "trigger":
+	default:
+		return e, fmt.Errorf("unknown pagerduty event_action: %q", p.EventAction)
+	}
+
+	severity := strings.ToLower(p.Severity)
+	switch severity {
+	case "":
+		severity = "error"
+	case "critical", "error", "warning", "info":
+	default:
+		return e, fmt.Errorf("unknown pagerduty severity: %q", p.Severity)
+	}
+
+	source := p.Source
+	if source == "" {
+		if source, _ = os.Hostname(); source == "" {
+			source = "thumper"
+		}
+	}
+
+	summary := p.Description
+	if summary == "" {
+		summary = c.Name
+	}
+	if len(summary) > pagerDutyMaxSummary {
+		// cut on a rune boundary so the summary stays valid utf-8
+		i := pagerDutyMaxSummary
+		for i > 0 && !utf8.RuneStart(summary[i]) {
+			i--
+		}
+		summary = summary[:i]
+	}
+
+	e.Client = "thumper"
+	e.Links = p.Links
+	e.Images = p.Images
+	e.Payload = &pagerDutyPayload{
+		Summary:       summary,
+		Source:        source,
+		Severity:      severity,
+		Component:     p.Component,
+		Group:         p.Group,
+		Class:         p.Class,
+		CustomDetails: p.Details,
+	}
+	return e, nil
+}
+
+// Do sends the event to the pagerduty api
+func (p *PagerDuty) Do(c context.Context) error {
+	e, err := p.event(c)
+	if err != nil {
+		return err
+	}
+	cl, err := timeoutClient(p.Timeout)
+	if err != nil {
+		return err
+	}
+	bodyb, err := json.Marshal(&e)
+	if err != nil {
+		return err
+	}
+
+	r, err := http.NewRequest("POST", pagerDutyURL, bytes.NewBuffer(bodyb))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := cl.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		res := struct {
+			Message string   `json:"message"`
+			Errors  []string `json:"errors"`
+		}{}
+		// ignore error, the body isn't json for some responses (e.g. 429s)
+		json.NewDecoder(resp.Body).Decode(&res)
+		msg := res.Message
+		if len(res.Errors) > 0 {
+			msg += ": " + strings.Join(res.Errors, ", ")
+		}
+		return fmt.Errorf("unexpected status code from pagerduty: %s. Message: %s", resp.Status, msg)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package action
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	. "testing"
+	"unicode/utf8"
+
+	"github.com/levenlabs/thumper/context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPagerDutyAction(t *T) {
+	var events []map[string]interface{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e map[string]interface{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&e))
+		if e["routing_key"] == "bad" {
+			w.WriteHeader(400)
+			fmt.Fprint(w, `{"status":"invalid event","message":"Event object is invalid","errors":["Length of 'routing_key' is incorrect"]}`)
+			return
+		}
+		events = append(events, e)
+		w.WriteHeader(202)
+		fmt.Fprint(w, `{"status":"success","message":"Event processed","dedup_key":"foo"}`)
+	}))
+	defer s.Close()
+
+	oldURL := pagerDutyURL
+	defer func() { pagerDutyURL = oldURL }()
+	pagerDutyURL = s.URL
+
+	c := context.Context{Name: "foo"}
+	a, err := ToActioner(map[string]interface{}{
+		"type":        "pagerduty",
+		"routing_key": "abc",
+		"description": "something broke",
+		"severity":    "Critical",
+		"source":      "web1",
+		"component":   "nginx",
+		"details":     map[string]interface{}{"count": 5},
+		"links":       []interface{}{map[string]interface{}{"href": "http://example.com", "text": "dashboard"}},
+	})
+	require.Nil(t, err)
+	require.Nil(t, a.Do(c))
+
+	p := &PagerDuty{RoutingKey: "abc", EventAction: "resolve"}
+	require.Nil(t, p.Do(c))
+
+	require.Len(t, events, 2)
+	assert.Equal(t, map[string]interface{}{
+		"routing_key":  "abc",
+		"event_action": "trigger",
+		"dedup_key":    "foo",
+		"client":       "thumper",
+		"payload": map[string]interface{}{
+			"summary":        "something broke",
+			"source":         "web1",
+			"severity":       "critical",
+			"component":      "nginx",
+			"custom_details": map[string]interface{}{"count": float64(5)},
+		},
+		"links": []interface{}{
+			map[string]interface{}{"href": "http://example.com", "text": "dashboard"},
+		},
+	}, events[0])
+	assert.Equal(t, map[string]interface{}{
+		"routing_key":  "abc",
+		"event_action": "resolve",
+		"dedup_key":    "foo",
+	}, events[1])
+
+	p = &PagerDuty{RoutingKey: "bad"}
+	err = p.Do(c)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Length of 'routing_key' is incorrect")
+
+	// long summaries are truncated without splitting a rune
+	p = &PagerDuty{RoutingKey: "abc", Description: "a" + strings.Repeat("é", pagerDutyMaxSummary)}
+	e, err := p.event(c)
+	require.Nil(t, err)
+	assert.Equal(t, "a"+strings.Repeat("é", pagerDutyMaxSummary/2-1), e.Payload.Summary)
+	assert.True(t, utf8.ValidString(e.Payload.Summary))
+
+	p = &PagerDuty{RoutingKey: "abc", Severity: "wat"}
+	assert.NotNil(t, p.Do(c))
+	p = &PagerDuty{RoutingKey: "abc", EventAction: "wat"}
+	assert.NotNil(t, p.Do(c))
+	p = &PagerDuty{RoutingKey: "abc", Timeout: "wat"}
+	assert.NotNil(t, p.Do(c))
+}
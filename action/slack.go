@@ -0,0 +1,245 @@
+package action
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/levenlabs/go-llog"
+	"github.com/levenlabs/thumper/config"
+	"github.com/levenlabs/thumper/context"
+)
+
+// The slack web api's base url, a variable so it can be changed in tests
+var slackAPIURL = "https://slack.com/api"
+
+// slackMessage identifies a message which has been posted to slack
+type slackMessage struct {
+	channel, ts string
+}
+
+// slackThread is the message which started a thread, or nil if the thread
+// hasn't been started. It's locked while a message for the thread is being
+// posted (which is bounded by the Slack's timeout), so that concurrent runs
+// can't each start a thread
+type slackThread struct {
+	sync.Mutex
+	msg *slackMessage
+}
+
+// slackThreads keeps track of each thread, keyed by the thread's key. It's
+// only kept in memory, so after a restart the next message for a thread starts
+// a new one
+var slackThreads = struct {
+	sync.Mutex
+	m map[string]*slackThread
+}{m: map[string]*slackThread{}}
+
+func getSlackThread(key string) *slackThread {
+	slackThreads.Lock()
+	defer slackThreads.Unlock()
+	th, ok := slackThreads.m[key]
+	if !ok {
+		th = &slackThread{}
+		slackThreads.m[key] = th
+	}
+	return th
+}
+
+// Slack posts a message to slack, either through an incoming webhook or the
+// chat.postMessage api. When using the api messages can be threaded, so that
+// all messages for an alert end up in the same thread, and the message which
+// started a thread can be updated once the alert resolves
+type Slack struct {
+	// At most one of these should be set. If neither are then --slack-token
+	// is used, or --slack-webhook-url if that isn't set either
+	WebhookURL string `mapstructure:"webhook_url"`
+	Token      string `mapstructure:"token"`
+
+	// Required when using the api, optional for webhooks
+	Channel string `mapstructure:"channel"`
+
+	Username  string `mapstructure:"username"`
+	IconEmoji string `mapstructure:"icon_emoji"`
+	IconURL   string `mapstructure:"icon_url"`
+
+	// At least one of these must be set. Text is used as the notification's
+	// fallback when Blocks or Attachments are set
+	Text        string        `mapstructure:"text"`
+	Blocks      []interface{} `mapstructure:"blocks"`
+	Attachments []interface{} `mapstructure:"attachments"`
+
+	// If set, the first message with a given ThreadKey starts a thread and
+	// later ones are posted as replies in it. ThreadKey defaults to the
+	// alert's name
+	Thread    bool   `mapstructure:"thread"`
+	ThreadKey string `mapstructure:"thread_key"`
+
+	// If set, thread replies are also posted to the channel
+	ReplyBroadcast bool `mapstructure:"reply_broadcast"`
+
+	// If set, the message which started the thread with the ThreadKey is
+	// replaced with this one and the thread is forgotten, so the next message
+	// starts a new thread. If there is no such thread nothing is posted
+	Resolve bool `mapstructure:"resolve"`
+
+	Timeout string `mapstructure:"timeout"` // Defaults to 30s
+}
+
+type slackPayload struct {
+	Channel        string        `json:"channel,omitempty"`
+	TS             string        `json:"ts,omitempty"`
+	ThreadTS       string        `json:"thread_ts,omitempty"`
+	ReplyBroadcast bool          `json:"reply_broadcast,omitempty"`
+	Username       string        `json:"username,omitempty"`
+	IconEmoji      string        `json:"icon_emoji,omitempty"`
+	IconURL        string        `json:"icon_url,omitempty"`
+	Text           string        `json:"text,omitempty"`
+	Blocks         []interface{} `json:"blocks,omitempty"`
+	Attachments    []interface{} `json:"attachments,omitempty"`
+}
+
+// Do posts the Slack's message
+func (s *Slack) Do(c context.Context) error {
+	if s.Text == "" && len(s.Blocks) == 0 && len(s.Attachments) == 0 {
+		return errors.New("one of text, blocks or attachments must be set in Slack")
+	}
+	cl, err := timeoutClient(s.Timeout)
+	if err != nil {
+		return err
+	}
+
+	p := slackPayload{
+		Channel:     s.Channel,
+		Username:    s.Username,
+		IconEmoji:   s.IconEmoji,
+		IconURL:     s.IconURL,
+		Text:        s.Text,
+		Blocks:      s.Blocks,
+		Attachments: s.Attachments,
+	}
+
+	token, webhookURL := s.Token, s.WebhookURL
+	if token == "" && webhookURL == "" {
+		token, webhookURL = config.SlackToken, config.SlackWebhookURL
+	}
+	if token == "" {
+		if webhookURL == "" {
+			return errors.New("slack token or webhook url not set in config or action")
+		} else if s.Thread || s.Resolve {
+			return errors.New("slack webhooks can't thread or update messages, a token is required")
+		}
+		return slackWebhook(cl, webhookURL, p)
+	}
+
+	if s.Channel == "" {
+		return errors.New("missing required field channel in Slack")
+	}
+	key := s.ThreadKey
+	if key == "" {
+		key = c.Name
+	}
+
+	th := getSlackThread(key)
+	if s.Resolve {
+		th.Lock()
+		defer th.Unlock()
+		m := th.msg
+		th.msg = nil
+		if m == nil {
+			llog.Debug("no slack thread to resolve", llog.KV{"threadKey": key})
+			return nil
+		}
+		p.Channel, p.TS = m.channel, m.ts
+		_, err := slackAPI(cl, "chat.update", token, p)
+		return err
+	}
+
+	if !s.Thread {
+		_, err := slackAPI(cl, "chat.postMessage", token, p)
+		return err
+	}
+
+	th.Lock()
+	defer th.Unlock()
+	if th.msg != nil {
+		p.ThreadTS = th.msg.ts
+		p.ReplyBroadcast = s.ReplyBroadcast
+		_, err := slackAPI(cl, "chat.postMessage", token, p)
+		return err
+	}
+
+	m, err := slackAPI(cl, "chat.postMessage", token, p)
+	if err != nil {
+		return err
+	}
+	th.msg = &m
+	return nil
+}
+
+// slackWebhook posts the payload to an incoming webhook. Webhooks respond
+// with a plain text body, which describes the error if there was one
+func slackWebhook(cl *http.Client, u string, p slackPayload) error {
+	bodyb, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	resp, err := cl.Post(u, "application/json", bytes.NewBuffer(bodyb))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code from slack: %s. Message: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// slackAPI calls the given slack api method with the payload, returning the
+// message which was posted or updated
+func slackAPI(cl *http.Client, method, token string, p slackPayload) (slackMessage, error) {
+	bodyb, err := json.Marshal(p)
+	if err != nil {
+		return slackMessage{}, err
+	}
+
+	r, err := http.NewRequest("POST", slackAPIURL+"/"+method, bytes.NewBuffer(bodyb))
+	if err != nil {
+		return slackMessage{}, err
+	}
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := cl.Do(r)
+	if err != nil {
+		return slackMessage{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return slackMessage{}, fmt.Errorf("unexpected status code from slack: %s", resp.Status)
+	}
+
+	// the api responds with a 200 even on errors, ok describes whether the
+	// call actually succeeded
+	res := struct {
+		OK      bool   `json:"ok"`
+		Error   string `json:"error"`
+		Channel string `json:"channel"`
+		TS      string `json:"ts"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return slackMessage{}, err
+	} else if !res.OK {
+		return slackMessage{}, fmt.Errorf("slack %s failed: %s", method, res.Error)
+	}
+	return slackMessage{channel: res.Channel, ts: res.TS}, nil
+}
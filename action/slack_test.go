@@ -0,0 +1,189 @@
+package action
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/levenlabs/thumper/context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlackAction(t *T) {
+	var calls []string
+	var bodies []map[string]interface{}
+	ts := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer xoxb", r.Header.Get("Authorization"))
+		var body map[string]interface{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+		if body["channel"] == "nope" {
+			fmt.Fprint(w, `{"ok":false,"error":"channel_not_found"}`)
+			return
+		}
+		calls = append(calls, r.URL.Path)
+		bodies = append(bodies, body)
+		ts++
+		fmt.Fprintf(w, `{"ok":true,"channel":"C123","ts":"%d.000"}`, ts)
+	})
+	mux.HandleFunc("/webhook", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+		if body["text"] == "" || body["text"] == nil {
+			w.WriteHeader(400)
+			fmt.Fprint(w, "invalid_payload")
+			return
+		}
+		calls = append(calls, r.URL.Path)
+		bodies = append(bodies, body)
+		fmt.Fprint(w, "ok")
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	oldURL := slackAPIURL
+	defer func() { slackAPIURL = oldURL }()
+	slackAPIURL = s.URL + "/api"
+
+	c := context.Context{Name: "foo"}
+	a, err := ToActioner(map[string]interface{}{
+		"type":       "slack",
+		"token":      "xoxb",
+		"channel":    "#alerts",
+		"username":   "thumper",
+		"icon_emoji": ":rotating_light:",
+		"text":       "foo is broken",
+		"thread":     true,
+		"blocks": []interface{}{
+			map[string]interface{}{
+				"type": "section",
+				"text": map[string]interface{}{"type": "mrkdwn", "text": "*foo* is broken"},
+			},
+		},
+	})
+	require.Nil(t, err)
+	require.Nil(t, a.Do(c))
+	require.Nil(t, a.Do(c))
+
+	sl := &Slack{Token: "xoxb", Channel: "#alerts", Text: "foo is fine", Resolve: true}
+	require.Nil(t, sl.Do(c))
+	// the thread was forgotten, so there's nothing to resolve
+	require.Nil(t, sl.Do(c))
+
+	sl = &Slack{WebhookURL: s.URL + "/webhook", Text: "hi", Attachments: []interface{}{
+		map[string]interface{}{"color": "danger", "text": "something"},
+	}}
+	require.Nil(t, sl.Do(c))
+
+	assert.Equal(t, []string{
+		"/api/chat.postMessage",
+		"/api/chat.postMessage",
+		"/api/chat.update",
+		"/webhook",
+	}, calls)
+	assert.Equal(t, map[string]interface{}{
+		"channel":    "#alerts",
+		"username":   "thumper",
+		"icon_emoji": ":rotating_light:",
+		"text":       "foo is broken",
+		"blocks": []interface{}{
+			map[string]interface{}{
+				"type": "section",
+				"text": map[string]interface{}{"type": "mrkdwn", "text": "*foo* is broken"},
+			},
+		},
+	}, bodies[0])
+	assert.Equal(t, "1.000", bodies[1]["thread_ts"])
+	assert.Equal(t, map[string]interface{}{
+		"channel": "C123",
+		"ts":      "1.000",
+		"text":    "foo is fine",
+	}, bodies[2])
+	assert.Equal(t, map[string]interface{}{
+		"text": "hi",
+		"attachments": []interface{}{
+			map[string]interface{}{"color": "danger", "text": "something"},
+		},
+	}, bodies[3])
+
+	sl = &Slack{Token: "xoxb", Channel: "nope", Text: "hi"}
+	err = sl.Do(c)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "channel_not_found")
+
+	sl = &Slack{WebhookURL: s.URL + "/webhook", Blocks: []interface{}{"wat"}}
+	err = sl.Do(c)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid_payload")
+
+	sl = &Slack{WebhookURL: s.URL + "/webhook", Text: "hi", Thread: true}
+	assert.NotNil(t, sl.Do(c))
+	sl = &Slack{Token: "xoxb", Channel: "#alerts"}
+	assert.NotNil(t, sl.Do(c))
+	sl = &Slack{Token: "xoxb", Channel: "#alerts", Text: "hi", Timeout: "wat"}
+	assert.NotNil(t, sl.Do(c))
+}
+
+func TestSlackActionThreadConcurrent(t *T) {
+	var l sync.Mutex
+	var started, replies int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		// give the other runs a chance to post while this one is in flight
+		time.Sleep(10 * time.Millisecond)
+		l.Lock()
+		defer l.Unlock()
+		if body["thread_ts"] == nil {
+			started++
+		} else {
+			replies++
+		}
+		fmt.Fprintf(w, `{"ok":true,"channel":"C123","ts":"%d.000"}`, started)
+	}))
+	defer s.Close()
+
+	oldURL := slackAPIURL
+	defer func() { slackAPIURL = oldURL }()
+	slackAPIURL = s.URL
+
+	c := context.Context{Name: "TestSlackActionThreadConcurrent"}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sl := &Slack{Token: "xoxb", Channel: "#alerts", Text: "foo is broken", Thread: true}
+			assert.Nil(t, sl.Do(c))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, started)
+	assert.Equal(t, 4, replies)
+}
+
+func TestSlackActionTimeout(t *T) {
+	done := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer s.Close()
+	defer close(done)
+
+	oldURL := slackAPIURL
+	defer func() { slackAPIURL = oldURL }()
+	slackAPIURL = s.URL
+
+	// a hung call gives up, and doesn't hold up later runs for the thread
+	c := context.Context{Name: "TestSlackActionTimeout"}
+	sl := &Slack{Token: "xoxb", Channel: "#alerts", Text: "foo is broken", Thread: true, Timeout: "10ms"}
+	assert.NotNil(t, sl.Do(c))
+	assert.NotNil(t, sl.Do(c))
+}
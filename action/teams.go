@@ -0,0 +1,98 @@
+package action
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/levenlabs/thumper/context"
+)
+
+// Adaptive cards can't be given arbitrary colors, only one of a set of styles,
+// so the severity determines the style used for the card's title
+var teamsStyles = map[string]string{
+	"critical": "attention",
+	"error":    "attention",
+	"warning":  "warning",
+	"info":     "accent",
+	"ok":       "good",
+}
+
+// Teams posts a message to a microsoft teams incoming webhook (or workflow
+// webhook) as an adaptive card. Color is ignored, since adaptive cards only
+// support the styles Severity maps to
+type Teams struct {
+	ChatMessage `mapstructure:",squash"`
+}
+
+type teamsAttachment struct {
+	ContentType string                 `json:"contentType"`
+	Content     map[string]interface{} `json:"content"`
+}
+
+type teamsPayload struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+// card builds the adaptive card for the Teams' message
+func (tm *Teams) card() map[string]interface{} {
+	var body []interface{}
+	if tm.Title != "" {
+		title := map[string]interface{}{
+			"type":   "TextBlock",
+			"text":   tm.Title,
+			"weight": "bolder",
+			"size":   "medium",
+			"wrap":   true,
+		}
+		if style, ok := teamsStyles[strings.ToLower(tm.Severity)]; ok {
+			body = append(body, map[string]interface{}{
+				"type":  "Container",
+				"style": style,
+				"bleed": true,
+				"items": []interface{}{title},
+			})
+		} else {
+			body = append(body, title)
+		}
+	}
+	if tm.Message != "" {
+		body = append(body, map[string]interface{}{
+			"type": "TextBlock",
+			"text": tm.Message,
+			"wrap": true,
+		})
+	}
+	if len(tm.Fields) > 0 {
+		facts := make([]interface{}, len(tm.Fields))
+		for i, f := range tm.Fields {
+			facts[i] = map[string]interface{}{"title": f.Title, "value": f.value()}
+		}
+		body = append(body, map[string]interface{}{
+			"type":  "FactSet",
+			"facts": facts,
+		})
+	}
+
+	return map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+	}
+}
+
+// Do posts the Teams' message
+func (tm *Teams) Do(_ context.Context) error {
+	if err := tm.validate(); err != nil {
+		return fmt.Errorf("%s in Teams", err)
+	}
+
+	return tm.post(teamsPayload{
+		Type: "message",
+		Attachments: []teamsAttachment{{
+			ContentType: "application/vnd.microsoft.card.adaptive",
+			Content:     tm.card(),
+		}},
+	})
+}
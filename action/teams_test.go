@@ -0,0 +1,82 @@
+package action
+
+import (
+	. "testing"
+
+	"github.com/levenlabs/thumper/context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTeamsAction(t *T) {
+	s, bodies := webhookStub(t)
+	defer s.Close()
+
+	c := context.Context{Name: "foo"}
+	a, err := ToActioner(map[string]interface{}{
+		"type":        "teams",
+		"webhook_url": s.URL,
+		"title":       "foo is broken",
+		"message":     "it matched 5 documents",
+		"severity":    "error",
+		"fields":      testChatFields,
+	})
+	require.Nil(t, err)
+	require.Nil(t, a.Do(c))
+
+	tm := &Teams{ChatMessage{WebhookURL: s.URL, Title: "foo is fine"}}
+	require.Nil(t, tm.Do(c))
+
+	require.Len(t, *bodies, 2)
+	card := func(body ...interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"type": "message",
+			"attachments": []interface{}{
+				map[string]interface{}{
+					"contentType": "application/vnd.microsoft.card.adaptive",
+					"content": map[string]interface{}{
+						"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+						"type":    "AdaptiveCard",
+						"version": "1.4",
+						"body":    body,
+					},
+				},
+			},
+		}
+	}
+	title := func(text string) map[string]interface{} {
+		return map[string]interface{}{
+			"type":   "TextBlock",
+			"text":   text,
+			"weight": "bolder",
+			"size":   "medium",
+			"wrap":   true,
+		}
+	}
+
+	assert.Equal(t, card(
+		map[string]interface{}{
+			"type":  "Container",
+			"style": "attention",
+			"bleed": true,
+			"items": []interface{}{title("foo is broken")},
+		},
+		map[string]interface{}{
+			"type": "TextBlock",
+			"text": "it matched 5 documents",
+			"wrap": true,
+		},
+		map[string]interface{}{
+			"type": "FactSet",
+			"facts": []interface{}{
+				map[string]interface{}{"title": "host", "value": "web1"},
+				map[string]interface{}{"title": "count", "value": "5"},
+				map[string]interface{}{"title": "empty", "value": ""},
+			},
+		},
+	), (*bodies)[0])
+	assert.Equal(t, card(title("foo is fine")), (*bodies)[1])
+
+	tm = &Teams{ChatMessage{Title: "foo"}}
+	assert.NotNil(t, tm.Do(c))
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"sync"
 	"text/template"
 	"time"
 
@@ -16,19 +18,143 @@ import (
 	"github.com/levenlabs/thumper/search"
 )
 
+// SearchBody is the body of a search. It may be given either as an object, in
+// which case go templating may be used within its string values, or as a
+// string, in which case the whole string is a go template which must render
+// into a yaml (or json) object. The latter allows for templates which render
+// objects, rather than just strings
+type SearchBody struct {
+	search.Dict
+	Template string
+}
+
+// UnmarshalYAML unmarshals either form of the SearchBody
+func (sb *SearchBody) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&sb.Template); err == nil {
+		return nil
+	}
+	sb.Template = ""
+	return unmarshal(&sb.Dict)
+}
+
+// AlertSearch describes a single search performed as part of an alert. All of
+// its fields may be go templates
+type AlertSearch struct {
+	// The datasource the search is performed against, see search.ToDatasource.
+	// Defaults to elasticsearch
+	Datasource search.Dict `yaml:"datasource"`
+
+	SearchIndex string     `yaml:"search_index"`
+	SearchType  string     `yaml:"search_type"`
+	Search      SearchBody `yaml:"search"`
+
+	// Alternatives to the query dsl. If either is set then it's used instead of
+	// the other search fields
+	SearchSQL string `yaml:"search_sql"`
+	SearchPPL string `yaml:"search_ppl"`
+
+	// The query to perform against the prometheus datasource
+	SearchPromQL string `yaml:"search_promql"`
+
+	// One of the search package's Mode constants, defaults to a normal search
+	SearchMode string `yaml:"search_mode"`
+
+	// If set, the search's hits are paged through rather than only the first
+	// page being returned
+	Paginate search.Pagination `yaml:"paginate"`
+
+	searchIndexTPL, searchTypeTPL, searchTPL    *template.Template
+	searchSQLTPL, searchPPLTPL, searchPromQLTPL *template.Template
+	datasourceTPL                               *template.Template
+
+	// The name of the search if it's one of an alert's named Searches
+	name string
+}
+
 // Alert encompasses a search query which will be run periodically, the results
 // of which will be checked against a condition. If the condition returns true a
 // set of actions will be performed
 type Alert struct {
-	Name        string            `yaml:"name"`
-	Interval    string            `yaml:"interval"`
-	SearchIndex string            `yaml:"search_index"`
-	SearchType  string            `yaml:"search_type"`
-	Search      search.Dict       `yaml:"search"`
-	Process     luautil.LuaRunner `yaml:"process"`
+	Name        string `yaml:"name"`
+	Interval    string `yaml:"interval"`
+	AlertSearch `yaml:",inline"`
+
+	// Searches are performed in addition to the top-level one, and each one's
+	// result is put in the context under its name
+	Searches         map[string]*AlertSearch `yaml:"searches"`
+	ParallelSearches bool                    `yaml:"parallel_searches"`
+
+	// If set, a metric of one of the alert's searches is compared against the
+	// same search performed over previous windows of time
+	Baseline Baseline `yaml:"baseline"`
+
+	// If set, searches which succeed but which failed on some shards are
+	// treated as having failed
+	FailOnShardFailures bool `yaml:"fail_on_shard_failures"`
+
+	// If set, the process step is still run when searches fail, with the error
+	// each failed with set on its result. The run still isn't considered
+	// successful
+	ProcessOnError bool `yaml:"process_on_error"`
 
-	cron                                     *cronexpr.Expression
-	searchIndexTPL, searchTypeTPL, searchTPL *template.Template
+	// The furthest back LastSuccess may be from the scheduled time, so that
+	// after a long run of failures the backlog isn't searched (and alerted
+	// on) all at once. Defaults to 10 intervals
+	MaxWindow string `yaml:"max_window"`
+
+	Process luautil.LuaRunner `yaml:"process"`
+
+	cron      *cronexpr.Expression
+	maxWindow time.Duration
+	state     *alertState
+}
+
+// The default MaxWindow, in intervals
+const defaultMaxWindowIntervals = 10
+
+// alertState holds data which is kept between runs of an alert. Since Alerts
+// are passed around by value this is always used through a pointer
+type alertState struct {
+	sync.Mutex
+	lastSuccess   time.Time
+	lastResponses map[string]context.Response
+}
+
+func (s *alertState) getLastSuccess() time.Time {
+	s.Lock()
+	defer s.Unlock()
+	return s.lastSuccess
+}
+
+// setLastSuccess sets the last successful run time, unless a run scheduled
+// after the given one has already succeeded
+func (s *alertState) setLastSuccess(t time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	if t.After(s.lastSuccess) {
+		s.lastSuccess = t
+	}
+}
+
+// getLastResponses returns a copy of the last response captured under each
+// name, so the copy can be used while more responses are captured
+func (s *alertState) getLastResponses() map[string]context.Response {
+	s.Lock()
+	defer s.Unlock()
+	m := make(map[string]context.Response, len(s.lastResponses))
+	for name, res := range s.lastResponses {
+		m[name] = res
+	}
+	return m
+}
+
+func (s *alertState) setLastResponse(name string, res context.Response) {
+	s.Lock()
+	defer s.Unlock()
+	if s.lastResponses == nil {
+		s.lastResponses = map[string]context.Response{}
+	}
+	s.lastResponses[name] = res
 }
 
 func templatizeHelper(i interface{}, lastErr error) (*template.Template, error) {
@@ -49,60 +175,191 @@ func templatizeHelper(i interface{}, lastErr error) (*template.Template, error)
 	return template.New("").Parse(str)
 }
 
+func (s *AlertSearch) init() error {
+	if !search.ValidMode(s.SearchMode) {
+		return fmt.Errorf("unknown search_mode: %q", s.SearchMode)
+	} else if s.Paginate.MaxHits > 0 && s.SearchMode != "" && s.SearchMode != search.ModeSearch {
+		return fmt.Errorf("paginate can't be used with search_mode %q", s.SearchMode)
+	} else if s.SearchSQL != "" && s.SearchPPL != "" {
+		return errors.New("only one of search_sql and search_ppl may be set")
+	} else if s.isSQL() && s.SearchMode != "" {
+		return errors.New("search_mode can't be used with sql or ppl queries")
+	}
+
+	ds, err := search.ToDatasource(s.Datasource)
+	if err != nil {
+		return err
+	} else if err := s.checkDatasource(ds); err != nil {
+		return err
+	}
+	if len(s.Datasource) > 0 {
+		if s.datasourceTPL, err = templatizeHelper(&s.Datasource, nil); err != nil {
+			return err
+		}
+	}
+
+	s.searchIndexTPL, err = templatizeHelper(s.SearchIndex, err)
+	s.searchTypeTPL, err = templatizeHelper(s.SearchType, err)
+	if s.Search.Template != "" {
+		s.searchTPL, err = templatizeHelper(s.Search.Template, err)
+	} else {
+		s.searchTPL, err = templatizeHelper(&s.Search.Dict, err)
+	}
+	s.searchSQLTPL, err = templatizeHelper(s.SearchSQL, err)
+	s.searchPPLTPL, err = templatizeHelper(s.SearchPPL, err)
+	s.searchPromQLTPL, err = templatizeHelper(s.SearchPromQL, err)
+	return err
+}
+
+// checkDatasource returns an error if the search uses any fields which its
+// datasource doesn't support
+func (s *AlertSearch) checkDatasource(ds search.Datasource) error {
+	_, isProm := ds.(*search.Prometheus)
+	switch {
+	case isProm && s.SearchPromQL == "":
+		return errors.New("search_promql is required for the prometheus datasource")
+	case !isProm && s.SearchPromQL != "":
+		return errors.New("search_promql can only be used with the prometheus datasource")
+	}
+
+	switch ds.(type) {
+	case *search.Elasticsearch:
+		return nil
+	case *search.SQLDatabase:
+		if s.SearchSQL == "" {
+			return errors.New("search_sql is required for the sql datasource")
+		} else if s.Paginate.MaxHits > 0 {
+			return errors.New("paginate can't be used with the sql datasource")
+		}
+		return nil
+	default:
+		if s.isSQL() || s.SearchMode != "" || s.Paginate.MaxHits > 0 {
+			return errors.New("search_sql, search_ppl, search_mode and paginate can only be used with the elasticsearch datasource")
+		}
+		return nil
+	}
+}
+
+// isSQL returns whether the search uses one of the alternatives to the query
+// dsl
+func (s *AlertSearch) isSQL() bool {
+	return s.SearchSQL != "" || s.SearchPPL != ""
+}
+
+// hasTopLevelSearch returns whether the alert defines a search outside of its
+// named Searches
+func (a *Alert) hasTopLevelSearch() bool {
+	return a.SearchIndex != "" || a.isSQL() || len(a.Datasource) > 0 || len(a.Searches) == 0
+}
+
 // Init initializes some internal data inside the Alert, and must be called
 // after the Alert is unmarshaled from yaml (or otherwise created)
 func (a *Alert) Init() error {
-	var err error
-	a.searchIndexTPL, err = templatizeHelper(a.SearchIndex, err)
-	a.searchTypeTPL, err = templatizeHelper(a.SearchType, err)
-	a.searchTPL, err = templatizeHelper(&a.Search, err)
-	if err != nil {
+	if err := a.AlertSearch.init(); err != nil {
 		return err
 	}
+	for name, s := range a.Searches {
+		if s == nil {
+			return fmt.Errorf("search %q is empty", name)
+		}
+		s.name = name
+		if err := s.init(); err != nil {
+			return fmt.Errorf("search %q: %s", name, err)
+		}
+	}
+
+	if a.Baseline.Windows > 0 {
+		if err := a.Baseline.init(a); err != nil {
+			return err
+		}
+	}
 
 	cron, err := cronexpr.Parse(a.Interval)
 	if err != nil {
 		return fmt.Errorf("parsing interval: %s", err)
 	}
 	a.cron = cron
+	a.state = &alertState{}
+
+	if a.MaxWindow != "" {
+		if a.maxWindow, err = time.ParseDuration(a.MaxWindow); err != nil {
+			return fmt.Errorf("parsing max_window: %s", err)
+		} else if a.maxWindow <= 0 {
+			return errors.New("max_window must be positive")
+		}
+	}
 
 	return nil
 }
 
-func (a Alert) Run() {
+// Run runs the alert for the given scheduled time. If the run completes
+// successfully the scheduled time is remembered as the alert's last successful
+// run, and the lines its file searches read are committed
+func (a Alert) Run(scheduled time.Time) {
 	kv := llog.KV{
 		"name": a.Name,
 	}
 	llog.Info("running alert", kv)
 
 	now := time.Now()
+	lastSuccess := a.windowStart(scheduled)
 	c := context.Context{
-		Name:      a.Name,
-		StartedTS: uint64(now.Unix()),
-		Time:      now,
+		Name:          a.Name,
+		StartedTS:     uint64(now.Unix()),
+		Time:          now,
+		ScheduledTS:   uint64(scheduled.Unix()),
+		Scheduled:     scheduled,
+		LastSuccessTS: uint64(lastSuccess.Unix()),
+		LastSuccess:   lastSuccess,
+		LastResponses: a.state.getLastResponses(),
 	}
 
-	searchIndex, searchType, searchQuery, err := a.createSearch(c)
-	if err != nil {
-		kv["err"] = err
-		llog.Error("failed to create search data", kv)
-		return
+	if a.run(c, kv) {
+		a.state.setLastSuccess(scheduled)
+		a.commitSearches()
 	}
+}
 
+// commitSearches marks the lines read by the alert's file searches as handled,
+// so the next run doesn't read them again
+func (a Alert) commitSearches() {
+	if a.hasTopLevelSearch() {
+		search.CommitFile(a.Name)
+	}
+	for name := range a.Searches {
+		search.CommitFile(a.Name + "." + name)
+	}
+}
+
+// run performs all the steps of the alert, returning whether or not they all
+// succeeded. Any errors will have already been logged
+func (a Alert) run(c context.Context, kv llog.KV) bool {
 	llog.Debug("running search step", kv)
-	res, err := search.Search(searchIndex, searchType, searchQuery)
-	if err != nil {
-		kv["err"] = err
-		llog.Error("failed at search step", kv)
-		return
+	searchesOK := a.doSearches(&c, kv)
+	if !searchesOK && !a.ProcessOnError {
+		return false
+	}
+
+	if a.Baseline.Windows > 0 && searchesOK {
+		llog.Debug("running baseline step", kv)
+		s := &a.AlertSearch
+		if a.Baseline.Search != "" {
+			s = a.Searches[a.Baseline.Search]
+		}
+		bl, err := a.Baseline.do(s, c)
+		if err != nil {
+			kv["err"] = err
+			llog.Error("failed at baseline step", kv)
+			return false
+		}
+		c.Baseline = bl
 	}
-	c.Result = res
 
 	llog.Debug("running process step", kv)
 	processRes, ok := a.Process.Do(c)
 	if !ok {
 		llog.Error("failed at process step", kv)
-		return
+		return false
 	}
 
 	// if processRes isn't an []interface{}, actionsRaw will be the nil value of
@@ -118,7 +375,7 @@ func (a Alert) Run() {
 		if err != nil {
 			kv["err"] = err
 			llog.Error("error unpacking action", kv)
-			return
+			return false
 		}
 		actions[i] = a
 	}
@@ -126,29 +383,216 @@ func (a Alert) Run() {
 	for i := range actions {
 		kv["action"] = actions[i].Type
 		llog.Info("performing action", kv)
-		if err := actions[i].Do(c); err != nil {
+		err := actions[i].Do(c)
+		if cp, ok := actions[i].Actioner.(action.Capturer); ok {
+			if name, res, ok := cp.Captured(); ok {
+				if c.Responses == nil {
+					c.Responses = map[string]context.Response{}
+				}
+				c.Responses[name] = res
+				a.state.setLastResponse(name, res)
+			}
+		}
+		if err != nil {
 			kv["err"] = err
 			llog.Error("failed to complete action", kv)
-			return
+			return false
 		}
 	}
+	return searchesOK
+}
+
+// windowStart returns the LastSuccess for a run scheduled at the given time.
+// It's clamped to MaxWindow before the scheduled time
+func (a Alert) windowStart(scheduled time.Time) time.Time {
+	lastSuccess := a.state.getLastSuccess()
+	if lastSuccess.IsZero() {
+		lastSuccess = a.prevScheduled(scheduled)
+	}
+
+	maxWindow := a.maxWindow
+	if maxWindow == 0 {
+		maxWindow = defaultMaxWindowIntervals * scheduled.Sub(a.prevScheduled(scheduled))
+	}
+	if earliest := scheduled.Add(-maxWindow); maxWindow > 0 && lastSuccess.Before(earliest) {
+		llog.Warn("alert hasn't succeeded within max_window, searching from there", llog.KV{
+			"name":        a.Name,
+			"lastSuccess": lastSuccess,
+			"maxWindow":   maxWindow,
+		})
+		lastSuccess = earliest
+	}
+	return lastSuccess
+}
+
+// prevScheduled approximates the time the alert would have been scheduled
+// before the given one, assuming a regular interval
+func (a Alert) prevScheduled(scheduled time.Time) time.Time {
+	next := a.cron.Next(scheduled)
+	return scheduled.Add(-next.Sub(scheduled))
+}
+
+// doSearches performs the alert's top-level search and all of its named
+// searches, filling in their results on the context. Returns false if any
+// search failed, in which case the error will have been logged. If
+// ProcessOnError is set the results of failed searches are filled in with
+// their errors
+func (a Alert) doSearches(c *context.Context, kv llog.KV) bool {
+	ok := true
+	if a.hasTopLevelSearch() {
+		res, err := a.search(&a.AlertSearch, *c)
+		if err != nil {
+			llog.Error("failed at search step", kv, llog.KV{"err": err})
+			if !a.ProcessOnError {
+				return false
+			}
+			res, ok = search.Result{Error: search.AsError(err)}, false
+		}
+		c.Result = res
+	}
+
+	if len(a.Searches) == 0 {
+		return ok
+	}
+
+	type namedResult struct {
+		name string
+		res  search.Result
+		err  error
+	}
+	ch := make(chan namedResult, len(a.Searches))
+	for name, s := range a.Searches {
+		if a.ParallelSearches {
+			go func(name string, s *AlertSearch) {
+				res, err := a.search(s, *c)
+				ch <- namedResult{name, res, err}
+			}(name, s)
+		} else {
+			res, err := a.search(s, *c)
+			ch <- namedResult{name, res, err}
+		}
+	}
+
+	c.Searches = make(map[string]search.Result, len(a.Searches))
+	for range a.Searches {
+		nr := <-ch
+		if nr.err != nil {
+			skv := llog.KV{"name": a.Name, "search": nr.name, "err": nr.err}
+			llog.Error("failed at search step", skv)
+			ok = false
+			if a.ProcessOnError {
+				c.Searches[nr.name] = search.Result{Error: search.AsError(nr.err)}
+			}
+			continue
+		}
+		c.Searches[nr.name] = nr.res
+	}
+	return ok
+}
+
+// search performs one of the alert's searches. If FailOnShardFailures is set
+// then shard failures are returned as an error
+func (a Alert) search(s *AlertSearch, c context.Context) (search.Result, error) {
+	res, err := s.do(c)
+	if err == nil && a.FailOnShardFailures {
+		err = res.ShardError()
+	}
+	return res, err
+}
+
+// do renders the search's templates using the given context and performs it
+// against the search's datasource
+func (s AlertSearch) do(c context.Context) (search.Result, error) {
+	ds, err := s.createDatasource(c)
+	if err != nil {
+		return search.Result{}, fmt.Errorf("creating datasource: %s", err)
+	}
+	q, err := s.createQuery(c)
+	if err != nil {
+		return search.Result{}, err
+	}
+	return ds.Search(q)
+}
+
+// createDatasource renders the search's datasource definition, if it has one,
+// and returns the Datasource it describes
+func (s AlertSearch) createDatasource(c context.Context) (search.Datasource, error) {
+	if s.datasourceTPL == nil {
+		return &search.Elasticsearch{}, nil
+	}
+
+	dsRaw, err := renderTPL(s.datasourceTPL, c)
+	if err != nil {
+		return nil, err
+	}
+	var ds search.Dict
+	if err := yaml.Unmarshal([]byte(dsRaw), &ds); err != nil {
+		return nil, err
+	}
+	return search.ToDatasource(ds)
+}
+
+// createQuery renders all of the search's templates into a Query
+func (s AlertSearch) createQuery(c context.Context) (search.Query, error) {
+	q := search.Query{
+		Mode:     s.SearchMode,
+		Paginate: s.Paginate,
+		Name:     c.Name,
+		Time:     c.Scheduled,
+	}
+	if s.name != "" {
+		q.Name += "." + s.name
+	}
+
+	var err error
+	if s.SearchPromQL != "" {
+		if q.PromQL, err = renderTPL(s.searchPromQLTPL, c); err != nil {
+			return q, fmt.Errorf("creating promql query: %s", err)
+		}
+		return q, nil
+	} else if s.SearchSQL != "" {
+		if q.SQL, err = renderTPL(s.searchSQLTPL, c); err != nil {
+			return q, fmt.Errorf("creating sql query: %s", err)
+		}
+		return q, nil
+	} else if s.SearchPPL != "" {
+		if q.PPL, err = renderTPL(s.searchPPLTPL, c); err != nil {
+			return q, fmt.Errorf("creating ppl query: %s", err)
+		}
+		return q, nil
+	}
+
+	var body interface{}
+	if q.Index, q.Type, body, err = s.createSearch(c); err != nil {
+		return q, fmt.Errorf("creating search data: %s", err)
+	}
+	q.Body, _ = body.(search.Dict)
+	return q, nil
+}
+
+func renderTPL(tpl *template.Template, c context.Context) (string, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, 1024))
+	if err := tpl.Execute(buf, &c); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
 }
 
-func (a Alert) createSearch(c context.Context) (string, string, interface{}, error) {
+func (s AlertSearch) createSearch(c context.Context) (string, string, interface{}, error) {
 	buf := bytes.NewBuffer(make([]byte, 0, 1024))
-	if err := a.searchIndexTPL.Execute(buf, &c); err != nil {
+	if err := s.searchIndexTPL.Execute(buf, &c); err != nil {
 		return "", "", nil, err
 	}
 	searchIndex := buf.String()
 
 	buf.Reset()
-	if err := a.searchTypeTPL.Execute(buf, &c); err != nil {
+	if err := s.searchTypeTPL.Execute(buf, &c); err != nil {
 		return "", "", nil, err
 	}
 	searchType := buf.String()
 
 	buf.Reset()
-	if err := a.searchTPL.Execute(buf, &c); err != nil {
+	if err := s.searchTPL.Execute(buf, &c); err != nil {
 		return "", "", nil, err
 	}
 	searchRaw := buf.Bytes()
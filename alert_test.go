@@ -2,6 +2,7 @@ package main
 
 import (
 	. "testing"
+	"time"
 
 	"github.com/levenlabs/thumper/context"
 	"github.com/levenlabs/thumper/search"
@@ -46,3 +47,149 @@ search: {
 	}
 	assert.Equal(t, expectedSearch, searchQuery)
 }
+
+func TestSearchesTPL(t *T) {
+	y := []byte(`
+interval: "* * * * *"
+searches:
+  errors:
+    search_index: foo-{{.Name}}
+    search: {
+      "query": {
+        "query_string": {
+          "query":"severity:error"
+        }
+      }
+    }
+  requests:
+    search_index: bar-{{.Name}}
+    search: {}`)
+
+	var a Alert
+	require.Nil(t, yaml.Unmarshal(y, &a))
+	require.Nil(t, a.Init())
+	assert.False(t, a.hasTopLevelSearch())
+	require.Len(t, a.Searches, 2)
+
+	c := context.Context{
+		Name: "wat",
+	}
+	searchIndex, searchType, _, err := a.Searches["errors"].createSearch(c)
+	require.Nil(t, err)
+	assert.Equal(t, "foo-wat", searchIndex)
+	assert.Equal(t, "", searchType)
+
+	searchIndex, _, _, err = a.Searches["requests"].createSearch(c)
+	require.Nil(t, err)
+	assert.Equal(t, "bar-wat", searchIndex)
+}
+
+func TestSearchTPLRangeFilter(t *T) {
+	y := []byte(`
+interval: "*/5 * * * *"
+search_index: foo
+search: |
+    {"query": {"bool": {"filter": [{{.RangeFilter "@timestamp"}}]}}}`)
+
+	var a Alert
+	require.Nil(t, yaml.Unmarshal(y, &a))
+	require.Nil(t, a.Init())
+
+	scheduled := time.Date(2018, 1, 1, 12, 5, 0, 0, time.UTC)
+	lastSuccess := a.prevScheduled(scheduled)
+	assert.Equal(t, time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC), lastSuccess)
+
+	c := context.Context{
+		Scheduled:   scheduled,
+		LastSuccess: lastSuccess,
+	}
+	_, _, searchQuery, err := a.createSearch(c)
+	require.Nil(t, err)
+	expectedSearch := search.Dict{
+		"query": search.Dict{
+			"bool": search.Dict{
+				"filter": []interface{}{
+					search.Dict{
+						"range": search.Dict{
+							"@timestamp": search.Dict{
+								"format": "epoch_millis",
+								"gte":    1514808000000,
+								"lt":     1514808300000,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	assert.Equal(t, expectedSearch, searchQuery)
+}
+
+func TestDatasourceTPL(t *T) {
+	y := []byte(`
+interval: "* * * * *"
+datasource:
+  type: http
+  url: http://example.com/{{.Name}}/status
+  headers:
+    X-Alert: "{{.Name}}"`)
+
+	var a Alert
+	require.Nil(t, yaml.Unmarshal(y, &a))
+	require.Nil(t, a.Init())
+	assert.True(t, a.hasTopLevelSearch())
+
+	c := context.Context{
+		Name: "wat",
+	}
+	ds, err := a.createDatasource(c)
+	require.Nil(t, err)
+	assert.Equal(t, &search.HTTP{
+		URL:     "http://example.com/wat/status",
+		Headers: map[string]string{"X-Alert": "wat"},
+	}, ds)
+
+	// elasticsearch specific options can't be used with other datasources
+	y = append(y, []byte("\nsearch_mode: count")...)
+	a = Alert{}
+	require.Nil(t, yaml.Unmarshal(y, &a))
+	assert.NotNil(t, a.Init())
+}
+
+func TestAlertState(t *T) {
+	s := &alertState{}
+	assert.True(t, s.getLastSuccess().IsZero())
+
+	now := time.Now()
+	s.setLastSuccess(now)
+	assert.Equal(t, now, s.getLastSuccess())
+
+	// an earlier run finishing after a later one shouldn't move the window
+	// backwards
+	s.setLastSuccess(now.Add(-time.Minute))
+	assert.Equal(t, now, s.getLastSuccess())
+}
+
+func TestAlertWindowStart(t *T) {
+	a := Alert{Name: "foo", Interval: "*/5 * * * *"}
+	require.Nil(t, a.Init())
+	scheduled := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// the first run covers one interval
+	assert.Equal(t, scheduled.Add(-5*time.Minute), a.windowStart(scheduled))
+
+	a.state.setLastSuccess(scheduled.Add(-30 * time.Minute))
+	assert.Equal(t, scheduled.Add(-30*time.Minute), a.windowStart(scheduled))
+
+	// by default the window is at most 10 intervals long
+	a.state = &alertState{lastSuccess: scheduled.Add(-24 * time.Hour)}
+	assert.Equal(t, scheduled.Add(-50*time.Minute), a.windowStart(scheduled))
+
+	a.MaxWindow = "2h"
+	require.Nil(t, a.Init())
+	a.state.setLastSuccess(scheduled.Add(-24 * time.Hour))
+	assert.Equal(t, scheduled.Add(-2*time.Hour), a.windowStart(scheduled))
+
+	a.MaxWindow = "wat"
+	assert.NotNil(t, a.Init())
+}
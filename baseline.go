@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/levenlabs/thumper/context"
+	"github.com/levenlabs/thumper/search"
+)
+
+const defaultBaselineOffset = 7 * 24 * time.Hour
+
+// At most this many of a baseline's windows are searched at once
+const maxBaselineSearches = 4
+
+// Baseline describes how to compare a metric of an alert's search against the
+// same search performed over previous comparable windows of time, e.g. the
+// same hour on the same weekday in previous weeks. Since the previous windows
+// are found by shifting the time of the context the search is templated
+// with, the search must use the context's times (e.g. via RangeFilter) rather
+// than hardcoding relative times like "now-5m"
+type Baseline struct {
+	// The number of previous windows to compare against. The baseline is
+	// disabled if this is 0
+	Windows int `yaml:"windows"`
+
+	// How far apart each window is, defaults to one week
+	Offset string `yaml:"offset"`
+
+	// The name of one of the alert's named searches to use. Defaults to the
+	// top-level search
+	Search string `yaml:"search"`
+
+	// The name of an aggregation whose value is used as the metric, rather than
+	// HitCount. Sub-aggregations of single bucket aggregations can be given
+	// using dots, e.g. "comments.authors"
+	Aggregation string `yaml:"aggregation"`
+
+	// If set, the named value of the aggregation to use (e.g. "max" for a stats
+	// aggregation or "99.0" for a percentiles aggregation), rather than its
+	// single value
+	Value string `yaml:"value"`
+
+	offset time.Duration
+}
+
+func (b *Baseline) init(a *Alert) error {
+	b.offset = defaultBaselineOffset
+	if b.Offset != "" {
+		var err error
+		if b.offset, err = time.ParseDuration(b.Offset); err != nil {
+			return fmt.Errorf("parsing baseline offset: %s", err)
+		} else if b.offset <= 0 {
+			return fmt.Errorf("baseline offset must be positive")
+		}
+	}
+
+	s := &a.AlertSearch
+	if b.Search == "" {
+		if !a.hasTopLevelSearch() {
+			return fmt.Errorf("baseline must name a search when there's no top-level search")
+		}
+	} else if s = a.Searches[b.Search]; s == nil {
+		return fmt.Errorf("baseline search %q not found", b.Search)
+	}
+
+	// the file datasource only returns what's new since its last search, so
+	// searching previous windows isn't possible
+	if ds, err := search.ToDatasource(s.Datasource); err != nil {
+		return err
+	} else if _, ok := ds.(*search.File); ok {
+		return fmt.Errorf("baseline can't be used with the file datasource")
+	}
+	return nil
+}
+
+// metric extracts the baseline's metric from a search result
+func (b Baseline) metric(res search.Result) (float64, error) {
+	if b.Aggregation == "" {
+		return float64(res.HitCount), nil
+	}
+
+	aggs := res.Aggs
+	var agg search.Aggregation
+	for _, name := range strings.Split(b.Aggregation, ".") {
+		var ok bool
+		if agg, ok = aggs[name]; !ok {
+			return 0, fmt.Errorf("aggregation %q not found in result", b.Aggregation)
+		}
+		aggs = agg.Aggs
+	}
+
+	if b.Value == "" {
+		return agg.Value, nil
+	}
+	v, ok := agg.Values[b.Value]
+	if !ok {
+		return 0, fmt.Errorf("value %q not found in aggregation %q", b.Value, b.Aggregation)
+	}
+	return v, nil
+}
+
+// current returns the result the baseline compares against from a context
+// which has had its searches performed
+func (b Baseline) current(c context.Context) search.Result {
+	if b.Search == "" {
+		return c.Result
+	}
+	return c.Searches[b.Search]
+}
+
+// do performs the baseline's search over each previous window, at most
+// maxBaselineSearches at a time, and computes how the current result compares
+// to them
+func (b Baseline) do(s *AlertSearch, c context.Context) (context.Baseline, error) {
+	current, err := b.metric(b.current(c))
+	if err != nil {
+		return context.Baseline{}, err
+	}
+
+	values := make([]float64, b.Windows)
+	errs := make([]error, b.Windows)
+	sem := make(chan struct{}, maxBaselineSearches)
+	var wg sync.WaitGroup
+	for i := range values {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			res, err := s.do(c.Shift(-time.Duration(i+1) * b.offset))
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			values[i], errs[i] = b.metric(res)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return context.Baseline{}, fmt.Errorf("baseline window %d: %s", i+1, err)
+		}
+	}
+	return newBaseline(current, values), nil
+}
+
+func newBaseline(current float64, values []float64) context.Baseline {
+	bl := context.Baseline{Current: current, Values: values}
+	if len(values) == 0 {
+		return bl
+	}
+
+	for _, v := range values {
+		bl.Mean += v
+	}
+	bl.Mean /= float64(len(values))
+
+	for _, v := range values {
+		bl.StdDev += (v - bl.Mean) * (v - bl.Mean)
+	}
+	bl.StdDev = math.Sqrt(bl.StdDev / float64(len(values)))
+
+	if bl.StdDev != 0 {
+		bl.ZScore = (current - bl.Mean) / bl.StdDev
+	}
+	return bl
+}
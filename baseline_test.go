@@ -0,0 +1,53 @@
+package main
+
+import (
+	. "testing"
+
+	"github.com/levenlabs/thumper/search"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBaseline(t *T) {
+	bl := newBaseline(20, []float64{2, 4, 4, 4, 5, 5, 7, 9})
+	assert.Equal(t, 5.0, bl.Mean)
+	assert.Equal(t, 2.0, bl.StdDev)
+	assert.Equal(t, 7.5, bl.ZScore)
+
+	// no variance means no z-score, rather than dividing by zero
+	bl = newBaseline(20, []float64{5, 5})
+	assert.Equal(t, 5.0, bl.Mean)
+	assert.Equal(t, 0.0, bl.ZScore)
+}
+
+func TestBaselineMetric(t *T) {
+	res := search.Result{
+		HitInfo: search.HitInfo{HitCount: 10},
+		Aggs: map[string]search.Aggregation{
+			"latency": {Values: map[string]float64{"99.0": 250}},
+			"comments": {
+				DocCount: 7,
+				Aggs: map[string]search.Aggregation{
+					"authors": {Value: 3},
+				},
+			},
+		},
+	}
+
+	v, err := Baseline{}.metric(res)
+	require.Nil(t, err)
+	assert.Equal(t, 10.0, v)
+
+	v, err = Baseline{Aggregation: "latency", Value: "99.0"}.metric(res)
+	require.Nil(t, err)
+	assert.Equal(t, 250.0, v)
+
+	v, err = Baseline{Aggregation: "comments.authors"}.metric(res)
+	require.Nil(t, err)
+	assert.Equal(t, 3.0, v)
+
+	_, err = Baseline{Aggregation: "wat"}.metric(res)
+	assert.NotNil(t, err)
+	_, err = Baseline{Aggregation: "latency", Value: "50.0"}.metric(res)
+	assert.NotNil(t, err)
+}
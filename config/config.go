@@ -4,19 +4,39 @@
 package config
 
 import (
+	"strings"
+	"time"
+
 	"github.com/levenlabs/go-llog"
 	"github.com/mediocregopher/lever"
 )
 
 var (
-	AlertFileDir      string
-	ElasticSearchAddr string
-	LuaInit           string
-	LuaVMs            int
-	PagerDutyKey      string
-	OpsGenieKey       string
-	ForceRun          string
-	LogLevel          string
+	AlertFileDir               string
+	ElasticSearchAddrs         []string
+	ElasticSearchSniff         bool
+	ElasticSearchSniffInterval time.Duration
+	ElasticSearchRetries       int
+	ElasticSearchFlavor        string
+	ElasticSearchUser          string
+	ElasticSearchPassword      string
+	ElasticSearchAPIKey        string
+	MSearchWindow              time.Duration
+	SearchCacheTTL             time.Duration
+	StatsAddr                  string
+	LuaInit                    string
+	LuaVMs                     int
+	PagerDutyKey               string
+	OpsGenieKey                string
+	OpsGenieRegion             string
+	SlackToken                 string
+	SlackWebhookURL            string
+	SMTPAddr                   string
+	SMTPUser                   string
+	SMTPPassword               string
+	SMTPFrom                   string
+	ForceRun                   string
+	LogLevel                   string
 )
 
 func init() {
@@ -28,9 +48,55 @@ func init() {
 	})
 	l.Add(lever.Param{
 		Name:        "--elasticsearch-addr",
-		Description: "Address to find an elasticsearch instance on",
+		Description: "Address to find an elasticsearch instance on, optionally prefixed with https://. May be a comma separated list of addresses, in which case searches are load-balanced across them",
 		Default:     "127.0.0.1:9200",
 	})
+	l.Add(lever.Param{
+		Name:        "--elasticsearch-sniff",
+		Description: "If set, the elasticsearch nodes api will be periodically queried to discover the full list of nodes in the cluster",
+		Flag:        true,
+	})
+	l.Add(lever.Param{
+		Name:        "--elasticsearch-sniff-interval",
+		Description: "How often to re-discover elasticsearch nodes when --elasticsearch-sniff is set",
+		Default:     "5m",
+	})
+	l.Add(lever.Param{
+		Name:        "--elasticsearch-retries",
+		Description: "How many times a failed search will be retried against a different elasticsearch node",
+		Default:     "2",
+	})
+	l.Add(lever.Param{
+		Name:        "--elasticsearch-flavor",
+		Description: "Which search engine the cluster is running. Valid options are: auto, elasticsearch, opensearch. auto detects it on startup",
+		Default:     "auto",
+	})
+	l.Add(lever.Param{
+		Name:        "--elasticsearch-user",
+		Description: "If set, basic auth with this username (and --elasticsearch-password) is used for all requests to the cluster",
+	})
+	l.Add(lever.Param{
+		Name:        "--elasticsearch-password",
+		Description: "Password to use alongside --elasticsearch-user",
+	})
+	l.Add(lever.Param{
+		Name:        "--elasticsearch-api-key",
+		Description: "If set, this base64 encoded api key is used to authenticate all requests to the cluster. Not supported by opensearch",
+	})
+	l.Add(lever.Param{
+		Name:        "--msearch-window",
+		Description: "If set, searches made within this amount of time of each other are batched into a single multi search request. Set to 0 to disable batching",
+		Default:     "0",
+	})
+	l.Add(lever.Param{
+		Name:        "--search-cache-ttl",
+		Description: "If set, the results of searches are cached for this long, so identical searches made by different alerts around the same time are only performed once. Set to 0 to disable caching",
+		Default:     "0",
+	})
+	l.Add(lever.Param{
+		Name:        "--stats-addr",
+		Description: "If set, an http server is started on this address which exposes internal stats (e.g. search cache hits and misses) as json at /debug/vars",
+	})
 	l.Add(lever.Param{
 		Name:        "--lua-init",
 		Description: "If set the given lua script will be executed at the initialization of every lua vm",
@@ -42,11 +108,40 @@ func init() {
 	})
 	l.Add(lever.Param{
 		Name:        "--pagerduty-key",
-		Description: "PagerDuty api key, required if using any pagerduty actions",
+		Description: "PagerDuty Events API v2 integration key, required if using any pagerduty actions which don't set their own routing_key",
 	})
 	l.Add(lever.Param{
 		Name:        "--opsgenie-key",
-		Description: "OpsGenie api key, required if using any opsgenie actions",
+		Description: "OpsGenie api key, required if using any opsgenie actions which don't set their own api_key",
+	})
+	l.Add(lever.Param{
+		Name:        "--opsgenie-region",
+		Description: "Which region's OpsGenie api to use by default. Valid options are: us, eu",
+		Default:     "us",
+	})
+	l.Add(lever.Param{
+		Name:        "--slack-token",
+		Description: "Slack bot token, used by slack actions which don't set their own token or webhook_url. Required for threading and updating messages",
+	})
+	l.Add(lever.Param{
+		Name:        "--slack-webhook-url",
+		Description: "Slack incoming webhook url, used by slack actions which don't set their own token or webhook_url, if --slack-token isn't set",
+	})
+	l.Add(lever.Param{
+		Name:        "--smtp-addr",
+		Description: "host:port of the smtp server used by email actions which don't set their own addr",
+	})
+	l.Add(lever.Param{
+		Name:        "--smtp-user",
+		Description: "If set, email actions which don't set their own username authenticate to the smtp server with this username (and --smtp-password)",
+	})
+	l.Add(lever.Param{
+		Name:        "--smtp-password",
+		Description: "Password to use alongside --smtp-user",
+	})
+	l.Add(lever.Param{
+		Name:        "--smtp-from",
+		Description: "The from address of email actions which don't set their own",
 	})
 	l.Add(lever.Param{
 		Name:        "--force-run",
@@ -60,12 +155,51 @@ func init() {
 	l.Parse()
 
 	AlertFileDir, _ = l.ParamStr("--alerts")
-	ElasticSearchAddr, _ = l.ParamStr("--elasticsearch-addr")
+	esAddrs, _ := l.ParamStr("--elasticsearch-addr")
+	ElasticSearchAddrs = splitList(esAddrs)
+	ElasticSearchSniff = l.ParamFlag("--elasticsearch-sniff")
+	ElasticSearchSniffInterval = paramDuration(l, "--elasticsearch-sniff-interval")
+	ElasticSearchRetries, _ = l.ParamInt("--elasticsearch-retries")
+	ElasticSearchFlavor, _ = l.ParamStr("--elasticsearch-flavor")
+	ElasticSearchUser, _ = l.ParamStr("--elasticsearch-user")
+	ElasticSearchPassword, _ = l.ParamStr("--elasticsearch-password")
+	ElasticSearchAPIKey, _ = l.ParamStr("--elasticsearch-api-key")
+	MSearchWindow = paramDuration(l, "--msearch-window")
+	SearchCacheTTL = paramDuration(l, "--search-cache-ttl")
+	StatsAddr, _ = l.ParamStr("--stats-addr")
 	LuaInit, _ = l.ParamStr("--lua-init")
 	LuaVMs, _ = l.ParamInt("--lua-vms")
 	LogLevel, _ = l.ParamStr("--log-level")
 	PagerDutyKey, _ = l.ParamStr("--pagerduty-key")
 	OpsGenieKey, _ = l.ParamStr("--opsgenie-key")
+	OpsGenieRegion, _ = l.ParamStr("--opsgenie-region")
+	SlackToken, _ = l.ParamStr("--slack-token")
+	SlackWebhookURL, _ = l.ParamStr("--slack-webhook-url")
+	SMTPAddr, _ = l.ParamStr("--smtp-addr")
+	SMTPUser, _ = l.ParamStr("--smtp-user")
+	SMTPPassword, _ = l.ParamStr("--smtp-password")
+	SMTPFrom, _ = l.ParamStr("--smtp-from")
 	ForceRun, _ = l.ParamStr("--force-run")
 	llog.SetLevelFromString(LogLevel)
 }
+
+// splitList splits a comma separated list, trimming whitespace and dropping
+// empty elements
+func splitList(s string) []string {
+	var l []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			l = append(l, e)
+		}
+	}
+	return l
+}
+
+func paramDuration(l *lever.Lever, name string) time.Duration {
+	s, _ := l.ParamStr(name)
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		llog.Fatal("invalid duration", llog.KV{"param": name, "value": s, "err": err})
+	}
+	return d
+}
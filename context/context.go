@@ -4,6 +4,9 @@
 package context
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/levenlabs/thumper/search"
@@ -16,4 +19,147 @@ type Context struct {
 	StartedTS     uint64
 	search.Result `luautil:",inline"`
 	time.Time     `luautil:"-"`
+
+	// The time this run of the alert was scheduled for, and the time the last
+	// successful run was scheduled for. Together these describe the window of
+	// time this run should cover
+	ScheduledTS   uint64
+	Scheduled     time.Time `luautil:"-"`
+	LastSuccessTS uint64
+	LastSuccess   time.Time `luautil:"-"`
+
+	// Results of the alert's named searches, keyed by name
+	Searches map[string]search.Result
+
+	// Filled in if the alert has a baseline defined
+	Baseline Baseline
+
+	// Responses captured by http actions during this run, keyed by the name
+	// they were captured under. Since actions are performed after the process
+	// step these are only available to the go templates of later actions
+	Responses map[string]Response
+
+	// The most recent response captured under each name by previous runs of
+	// the alert
+	LastResponses map[string]Response
+}
+
+// Response describes an http action's response which was captured
+type Response struct {
+	StatusCode int
+	Headers    map[string]string // Only the first value of each header is kept
+	Body       string
+	Data       interface{} // Body decoded as json, if it's json
+	TS         uint64      // When the response was received
+}
+
+// Baseline describes how a metric of the current run of an alert compares to
+// the same metric in previous comparable windows of time
+type Baseline struct {
+	Current float64   // The metric's value in the current run
+	Values  []float64 // The metric's value in each previous window, most recent first
+	Mean    float64   // The mean of Values
+	StdDev  float64   // The standard deviation of Values
+	ZScore  float64   // How many standard deviations Current is from Mean
+}
+
+// Shift returns a copy of the Context with all of its times moved by the given
+// duration. Search results are not modified
+func (c Context) Shift(d time.Duration) Context {
+	c.Time = c.Time.Add(d)
+	c.Scheduled = c.Scheduled.Add(d)
+	c.LastSuccess = c.LastSuccess.Add(d)
+	c.StartedTS = uint64(c.Time.Unix())
+	c.ScheduledTS = uint64(c.Scheduled.Unix())
+	c.LastSuccessTS = uint64(c.LastSuccess.Unix())
+	return c
+}
+
+// RangeFilter returns a json range filter on the given timestamp field which
+// matches documents from LastSuccess (inclusive) up to Scheduled (exclusive),
+// so that consecutive runs of an alert neither miss nor double count documents
+func (c Context) RangeFilter(field string) (string, error) {
+	filter := map[string]interface{}{
+		"range": map[string]interface{}{
+			field: map[string]interface{}{
+				"gte":    unixMillis(c.LastSuccess),
+				"lt":     unixMillis(c.Scheduled),
+				"format": "epoch_millis",
+			},
+		},
+	}
+	b, err := json.Marshal(filter)
+	return string(b), err
+}
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// IndexRange returns a comma separated list of all the indices which cover the
+// given time range. The pattern should contain a "%s", which is replaced with
+// the date of each index formatted using the given go time format (if it
+// doesn't the date is appended). The format's granularity determines the
+// indices returned, e.g. "2006.01.02" gives daily indices and "2006.01.02.15"
+// hourly ones. Dates are always in UTC.
+//
+//	{{.IndexRange "logstash-%s" "2006.01.02" .LastSuccess .Scheduled}}
+func (c Context) IndexRange(pattern, format string, from, to time.Time) string {
+	return strings.Join(indexRange(pattern, format, from, to), ",")
+}
+
+// ExistingIndexRange is like IndexRange, except that the cluster is queried
+// for which of the indices actually exist and only those are returned. An
+// error is returned if none of them exist
+func (c Context) ExistingIndexRange(pattern, format string, from, to time.Time) (string, error) {
+	indices := indexRange(pattern, format, from, to)
+	existing, err := search.ResolveIndex(indexPattern(pattern, "*"))
+	if err != nil {
+		return "", err
+	}
+
+	existingM := make(map[string]bool, len(existing))
+	for _, e := range existing {
+		existingM[e] = true
+	}
+
+	filtered := indices[:0]
+	for _, index := range indices {
+		if existingM[index] {
+			filtered = append(filtered, index)
+		}
+	}
+	if len(filtered) == 0 {
+		return "", fmt.Errorf("none of the indices %v exist", indices)
+	}
+	return strings.Join(filtered, ","), nil
+}
+
+func indexPattern(pattern, date string) string {
+	if !strings.Contains(pattern, "%s") {
+		return pattern + date
+	}
+	return strings.Replace(pattern, "%s", date, -1)
+}
+
+// indexRange steps through the time range an hour at a time, since that's the
+// smallest granularity indices are generally created at, and collects the
+// distinct index names
+func indexRange(pattern, format string, from, to time.Time) []string {
+	from, to = from.UTC(), to.UTC()
+	var indices []string
+	seen := map[string]bool{}
+	add := func(t time.Time) {
+		index := indexPattern(pattern, t.Format(format))
+		if !seen[index] {
+			seen[index] = true
+			indices = append(indices, index)
+		}
+	}
+
+	add(from)
+	for t := from.Truncate(time.Hour).Add(time.Hour); t.Before(to); t = t.Add(time.Hour) {
+		add(t)
+	}
+	return indices
 }
@@ -0,0 +1,60 @@
+package context
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeFilter(t *T) {
+	c := Context{
+		Scheduled:   time.Unix(1514764800, 0),
+		LastSuccess: time.Unix(1514764500, 0),
+	}
+	f, err := c.RangeFilter("@timestamp")
+	require.Nil(t, err)
+	assert.Equal(t, `{"range":{"@timestamp":{"format":"epoch_millis","gte":1514764500000,"lt":1514764800000}}}`, f)
+}
+
+func TestIndexRange(t *T) {
+	c := Context{}
+	from := time.Date(2018, 1, 1, 23, 30, 0, 0, time.UTC)
+	to := time.Date(2018, 1, 2, 0, 30, 0, 0, time.UTC)
+
+	assert.Equal(t, "logstash-2018.01.01,logstash-2018.01.02",
+		c.IndexRange("logstash-%s", "2006.01.02", from, to))
+	assert.Equal(t, "logstash-2018.01.01.23,logstash-2018.01.02.00",
+		c.IndexRange("logstash-", "2006.01.02.15", from, to))
+	assert.Equal(t, "logs-2018.01-app",
+		c.IndexRange("logs-%s-app", "2006.01", from, to))
+
+	// a range which ends exactly at midnight doesn't need the next day's index
+	to = time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "logstash-2018.01.01", c.IndexRange("logstash-%s", "2006.01.02", from, to))
+
+	// times are converted to UTC
+	est := time.FixedZone("EST", -5*60*60)
+	from = time.Date(2018, 1, 1, 20, 0, 0, 0, est)
+	assert.Equal(t, "logstash-2018.01.02", c.IndexRange("logstash-%s", "2006.01.02", from, from))
+}
+
+func TestShift(t *T) {
+	now := time.Date(2018, 1, 8, 12, 5, 0, 0, time.UTC)
+	c := Context{
+		Time:        now,
+		Scheduled:   now,
+		LastSuccess: now.Add(-5 * time.Minute),
+	}
+	week := 7 * 24 * time.Hour
+	s := c.Shift(-week)
+	assert.Equal(t, now.Add(-week), s.Time)
+	assert.Equal(t, now.Add(-week), s.Scheduled)
+	assert.Equal(t, now.Add(-week-5*time.Minute), s.LastSuccess)
+	assert.Equal(t, uint64(now.Add(-week).Unix()), s.ScheduledTS)
+	assert.Equal(t, uint64(now.Add(-week-5*time.Minute).Unix()), s.LastSuccessTS)
+
+	// original is untouched
+	assert.Equal(t, now, c.Scheduled)
+}
@@ -0,0 +1,83 @@
+package luautil
+
+import (
+	"github.com/Shopify/go-lua"
+)
+
+// helpers is lua code which is run at the initialization of every lua vm, and
+// defines helper functions which are available to all lua scripts
+const helpers = `
+-- agg contains helpers for working with the normalized aggregations in
+-- ctx.Aggs
+agg = {}
+
+-- agg.buckets returns an iterator over the key and bucket of each of the
+-- aggregation's buckets, e.g.
+--    for key, b in agg.buckets(ctx.Aggs.by_host) do ... end
+function agg.buckets(a)
+    local i = 0
+    local buckets = (a and a.Buckets) or {}
+    return function()
+        i = i + 1
+        local b = buckets[i]
+        if b then return b.Key, b end
+    end
+end
+
+-- agg.bucket returns the aggregation's bucket with the given key, or nil. Keys
+-- are compared as strings, so agg.bucket(a, 200) and agg.bucket(a, "200") are
+-- equivalent
+function agg.bucket(a, key)
+    for k, b in agg.buckets(a) do
+        if tostring(k) == tostring(key) or b.KeyAsString == key then
+            return b
+        end
+    end
+    return nil
+end
+
+-- agg.keys returns a list of the keys of the aggregation's buckets, in order
+function agg.keys(a)
+    local keys = {}
+    for k in agg.buckets(a) do
+        keys[#keys + 1] = k
+    end
+    return keys
+end
+
+-- agg.metric returns a metric of the aggregation. With no name the
+-- aggregation's single value is returned (e.g. for avg or cardinality),
+-- otherwise the named value is returned (e.g. "max" for stats, or "99" for
+-- percentiles). Returns nil if the value isn't present
+function agg.metric(a, name)
+    if not a then return nil end
+    if name == nil then return a.Value end
+    local values = a.Values or {}
+    if values[name] ~= nil then return values[name] end
+    local n = tonumber(name)
+    if n == nil then return nil end
+    for k, v in pairs(values) do
+        if tonumber(k) == n then return v end
+    end
+    return nil
+end
+
+-- agg.sum returns the sum of the doc counts of all of the aggregation's
+-- buckets. If sub is given, the sum of agg.metric(b.Aggs[sub], name) over all
+-- buckets is returned instead
+function agg.sum(a, sub, name)
+    local total = 0
+    for _, b in agg.buckets(a) do
+        if sub == nil then
+            total = total + b.DocCount
+        else
+            total = total + (agg.metric((b.Aggs or {})[sub], name) or 0)
+        end
+    end
+    return total
+end
+`
+
+func loadHelpers(l *lua.State) error {
+	return lua.DoString(l, helpers)
+}
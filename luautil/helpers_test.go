@@ -0,0 +1,49 @@
+package luautil
+
+import (
+	. "testing"
+
+	"github.com/levenlabs/thumper/search"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggHelpers(t *T) {
+	aggs := search.ParseAggregations(map[string]interface{}{
+		"by_host": map[string]interface{}{
+			"buckets": []interface{}{
+				map[string]interface{}{
+					"key":       "web1",
+					"doc_count": float64(3),
+					"latency":   map[string]interface{}{"value": float64(10)},
+				},
+				map[string]interface{}{
+					"key":       "web2",
+					"doc_count": float64(5),
+					"latency":   map[string]interface{}{"value": float64(20)},
+				},
+			},
+		},
+		"latency": map[string]interface{}{
+			"values": map[string]interface{}{"50.0": float64(12), "99.0": float64(80)},
+		},
+	})
+
+	l := testLuaState()
+	require.Nil(t, loadHelpers(l))
+	testPushFromWithState(t, l, map[string]interface{}{"Aggs": aggs}, `
+		local keys = agg.keys(ctx.Aggs.by_host)
+		if #keys ~= 2 or keys[1] ~= "web1" or keys[2] ~= "web2" then return false end
+		if agg.bucket(ctx.Aggs.by_host, "web2").DocCount ~= 5 then return false end
+		if agg.bucket(ctx.Aggs.by_host, "web3") ~= nil then return false end
+		if agg.sum(ctx.Aggs.by_host) ~= 8 then return false end
+		if agg.sum(ctx.Aggs.by_host, "latency") ~= 30 then return false end
+		if agg.metric(ctx.Aggs.latency, "99") ~= 80 then return false end
+		if agg.metric(ctx.Aggs.latency, "50.0") ~= 12 then return false end
+		if agg.metric(ctx.Aggs.latency, "75") ~= nil then return false end
+		local n = 0
+		for key, b in agg.buckets(ctx.Aggs.by_host) do
+			n = n + b.Aggs.latency.Value
+		end
+		return n == 30
+	`)
+}
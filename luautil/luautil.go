@@ -107,6 +107,11 @@ func (r *runner) spin() {
 	kv := llog.KV{"runnerID": r.id}
 	llog.Info("initializing lua vm", kv)
 
+	if err := loadHelpers(r.l); err != nil {
+		kv["err"] = err
+		llog.Fatal("error loading lua helpers", kv)
+	}
+
 	if config.LuaInit != "" {
 		initKV := llog.KV{"runnerID": r.id, "filename": config.LuaInit}
 		initFnName, err := r.loadFile(config.LuaInit)
@@ -49,6 +49,19 @@ func TestPullArbitrary(t *T) {
 	}, i)
 }
 
+// testPushFromWithState pushes the given value as the global ctx on the given
+// lua state, and asserts that the given code returns true
+func testPushFromWithState(t *T, l *lua.State, i interface{}, code string) {
+	pushArbitraryValue(l, i)
+	l.SetGlobal("ctx")
+
+	b := bytes.NewBufferString(code)
+	require.Nil(t, l.Load(b, "", "bt"))
+	l.Call(0, 1)
+	assert.True(t, l.ToBoolean(-1))
+	l.Remove(-1)
+}
+
 func testPushFrom(t *T, f func(*lua.State, reflect.Value), i interface{}, code string) {
 	l := testLuaState()
 	initialStackSize := l.Top()
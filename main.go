@@ -1,7 +1,9 @@
 package main
 
 import (
+	_ "expvar"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"time"
@@ -10,6 +12,7 @@ import (
 
 	"github.com/levenlabs/go-llog"
 	"github.com/levenlabs/thumper/config"
+	"github.com/levenlabs/thumper/search"
 )
 
 func main() {
@@ -17,6 +20,21 @@ func main() {
 		llog.Fatal("--alerts must be set")
 	}
 
+	if v, err := search.DetectVersion(); err != nil {
+		llog.Warn("could not detect elasticsearch version", llog.KV{"err": err})
+	} else {
+		llog.Info("detected elasticsearch version", llog.KV{"version": v})
+	}
+
+	if config.StatsAddr != "" {
+		go func() {
+			kv := llog.KV{"addr": config.StatsAddr}
+			llog.Info("listening for stats requests", kv)
+			err := http.ListenAndServe(config.StatsAddr, nil)
+			llog.Fatal("stats listener failed", kv, llog.ErrKV(err))
+		}()
+	}
+
 	fstat, err := os.Stat(config.AlertFileDir)
 	if err != nil {
 		llog.Fatal("failed getting alert definitions", llog.KV{"err": err})
@@ -60,7 +78,7 @@ func main() {
 			}
 
 			if config.ForceRun != "" && config.ForceRun == alerts[i].Name {
-				alerts[i].Run()
+				alerts[i].Run(time.Now())
 				time.Sleep(250 * time.Millisecond) // allow time for logs to print
 				return
 			} else if config.ForceRun == "" {
@@ -84,6 +102,6 @@ func alertSpin(a Alert) {
 		now := time.Now()
 		next := a.cron.Next(now)
 		time.Sleep(next.Sub(now))
-		go a.Run()
+		go a.Run(next)
 	}
 }
@@ -0,0 +1,198 @@
+package search
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Aggregation is a normalized form of a single aggregation's result, so that
+// the results of the common aggregation types can be worked with the same way
+// without needing to know their exact shape. Which fields are filled in depends
+// on the type of the aggregation
+type Aggregation struct {
+	// Single-value metrics (avg, sum, min, max, cardinality, value_count, etc...)
+	Value float64
+
+	// Multi-value metrics, e.g. the count/min/max/avg/sum of a stats
+	// aggregation, or each percentile of a percentiles aggregation (keyed like
+	// "99.0")
+	Values map[string]float64
+
+	// Single bucket aggregations (filter, nested, etc...)
+	DocCount uint64
+
+	// Multi-bucket aggregations (terms, date_histogram, histogram, range,
+	// filters, etc...)
+	Buckets []Bucket
+
+	// Sub-aggregations of single bucket aggregations
+	Aggs map[string]Aggregation
+}
+
+// Bucket is a single bucket of a multi-bucket aggregation
+type Bucket struct {
+	Key         interface{} // A string or number, depending on the aggregation
+	KeyAsString string      // The formatted key, if the aggregation has one (e.g. date_histogram)
+	DocCount    uint64
+	Aggs        map[string]Aggregation // Sub-aggregations of the bucket
+}
+
+// aggregation response fields which aren't useful enough to be normalized
+func ignoredAggField(k string) bool {
+	return k == "meta" ||
+		k == "doc_count_error_upper_bound" ||
+		k == "sum_other_doc_count" ||
+		strings.HasSuffix(k, "_as_string")
+}
+
+// ParseAggregations normalizes the aggregations field of a search response
+func ParseAggregations(raw map[string]interface{}) map[string]Aggregation {
+	if raw == nil {
+		return nil
+	}
+	aggs := make(map[string]Aggregation, len(raw))
+	for name, v := range raw {
+		if m, ok := v.(map[string]interface{}); ok {
+			aggs[name] = parseAggregation(m)
+		}
+	}
+	return aggs
+}
+
+func parseAggregation(m map[string]interface{}) Aggregation {
+	var a Aggregation
+	for k, v := range m {
+		if ignoredAggField(k) {
+			continue
+		}
+		switch k {
+		case "value":
+			a.Value, _ = v.(float64)
+		case "values":
+			a.Values = mergeValues(a.Values, parseValues(v))
+		case "doc_count":
+			a.DocCount = toUint64(v)
+		case "buckets":
+			a.Buckets = parseBuckets(v)
+		default:
+			switch vv := v.(type) {
+			case float64:
+				a.Values = mergeValues(a.Values, map[string]float64{k: vv})
+			case map[string]interface{}:
+				if a.Aggs == nil {
+					a.Aggs = map[string]Aggregation{}
+				}
+				a.Aggs[k] = parseAggregation(vv)
+			}
+		}
+	}
+	return a
+}
+
+// parseValues handles the values field of percentiles style aggregations,
+// which is either an object or, if keyed is false, an array of key/value
+// objects
+func parseValues(v interface{}) map[string]float64 {
+	values := map[string]float64{}
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		for k, val := range vv {
+			if f, ok := val.(float64); ok {
+				values[k] = f
+			}
+		}
+	case []interface{}:
+		for _, e := range vv {
+			em, _ := e.(map[string]interface{})
+			f, ok := em["value"].(float64)
+			if !ok {
+				continue
+			}
+			switch key := em["key"].(type) {
+			case string:
+				values[key] = f
+			case float64:
+				values[formatPercentKey(key)] = f
+			}
+		}
+	}
+	return values
+}
+
+// formatPercentKey formats a numeric percentiles key the same way elasticsearch
+// does when keyed is true, e.g. 99 -> "99.0"
+func formatPercentKey(f float64) string {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// parseBuckets handles the buckets field of multi-bucket aggregations, which
+// is either an array or, if keyed is true, an object
+func parseBuckets(v interface{}) []Bucket {
+	switch vv := v.(type) {
+	case []interface{}:
+		buckets := make([]Bucket, 0, len(vv))
+		for _, e := range vv {
+			if em, ok := e.(map[string]interface{}); ok {
+				buckets = append(buckets, parseBucket(em["key"], em))
+			}
+		}
+		return buckets
+	case map[string]interface{}:
+		keys := make([]string, 0, len(vv))
+		for k := range vv {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buckets := make([]Bucket, 0, len(vv))
+		for _, k := range keys {
+			if em, ok := vv[k].(map[string]interface{}); ok {
+				buckets = append(buckets, parseBucket(k, em))
+			}
+		}
+		return buckets
+	}
+	return nil
+}
+
+func parseBucket(key interface{}, m map[string]interface{}) Bucket {
+	b := Bucket{Key: key}
+	b.KeyAsString, _ = m["key_as_string"].(string)
+	for k, v := range m {
+		if ignoredAggField(k) {
+			continue
+		}
+		switch k {
+		case "key":
+		case "doc_count":
+			b.DocCount = toUint64(v)
+		default:
+			if vm, ok := v.(map[string]interface{}); ok {
+				if b.Aggs == nil {
+					b.Aggs = map[string]Aggregation{}
+				}
+				b.Aggs[k] = parseAggregation(vm)
+			}
+		}
+	}
+	return b
+}
+
+func mergeValues(dst, src map[string]float64) map[string]float64 {
+	if dst == nil {
+		return src
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+func toUint64(v interface{}) uint64 {
+	f, _ := v.(float64)
+	return uint64(f)
+}
@@ -0,0 +1,71 @@
+package search
+
+import (
+	"encoding/json"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAggregations(t *T) {
+	body := `{
+		"hits": {"total": 100, "hits": []},
+		"aggregations": {
+			"by_status": {
+				"doc_count_error_upper_bound": 0,
+				"sum_other_doc_count": 0,
+				"buckets": [
+					{"key": 200, "doc_count": 90, "latency": {"value": 12.5}},
+					{"key": 500, "doc_count": 10, "latency": {"value": 150}}
+				]
+			},
+			"over_time": {
+				"buckets": [
+					{"key_as_string": "2018-01-01T00:00:00.000Z", "key": 1514764800000, "doc_count": 100}
+				]
+			},
+			"latency": {"values": {"50.0": 10, "99.0": 200}},
+			"latency_list": {"values": [{"key": 50, "value": 10}, {"key": 99.9, "value": 300}]},
+			"latency_stats": {"count": 100, "min": 1, "max": 300, "avg": 20, "sum": 2000},
+			"users": {"value": 42},
+			"empty_avg": {"value": null},
+			"errors": {
+				"buckets": {
+					"fatal": {"doc_count": 2},
+					"warn": {"doc_count": 8}
+				}
+			},
+			"comments": {
+				"doc_count": 7,
+				"authors": {"value": 3}
+			}
+		}
+	}`
+
+	var r Result
+	require.Nil(t, json.Unmarshal([]byte(body), &r))
+	aggs := r.Aggs
+
+	require.Len(t, aggs["by_status"].Buckets, 2)
+	assert.Equal(t, float64(200), aggs["by_status"].Buckets[0].Key)
+	assert.Equal(t, uint64(90), aggs["by_status"].Buckets[0].DocCount)
+	assert.Equal(t, 150.0, aggs["by_status"].Buckets[1].Aggs["latency"].Value)
+
+	require.Len(t, aggs["over_time"].Buckets, 1)
+	assert.Equal(t, "2018-01-01T00:00:00.000Z", aggs["over_time"].Buckets[0].KeyAsString)
+
+	assert.Equal(t, map[string]float64{"50.0": 10, "99.0": 200}, aggs["latency"].Values)
+	assert.Equal(t, map[string]float64{"50.0": 10, "99.9": 300}, aggs["latency_list"].Values)
+	assert.Equal(t, map[string]float64{"count": 100, "min": 1, "max": 300, "avg": 20, "sum": 2000}, aggs["latency_stats"].Values)
+	assert.Equal(t, 42.0, aggs["users"].Value)
+	assert.Equal(t, 0.0, aggs["empty_avg"].Value)
+
+	assert.Equal(t, []Bucket{
+		{Key: "fatal", DocCount: 2},
+		{Key: "warn", DocCount: 8},
+	}, aggs["errors"].Buckets)
+
+	assert.Equal(t, uint64(7), aggs["comments"].DocCount)
+	assert.Equal(t, 3.0, aggs["comments"].Aggs["authors"].Value)
+}
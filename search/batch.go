@@ -0,0 +1,165 @@
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/levenlabs/go-llog"
+	"github.com/levenlabs/thumper/config"
+)
+
+type batchRes struct {
+	res Result
+	err error
+}
+
+type batchReq struct {
+	index, typ string
+	body       []byte
+	retCh      chan batchRes
+}
+
+// batcher collects searches made within a short window of each other and
+// performs them all in a single multi search request. Since there's only one
+// cluster searches are made against, all searches can be batched together
+type batcher struct {
+	sync.Mutex
+	window  time.Duration
+	pending []batchReq
+}
+
+// batch is nil if batching is disabled
+var batch *batcher
+
+func init() {
+	if config.MSearchWindow > 0 {
+		batch = &batcher{window: config.MSearchWindow}
+	}
+}
+
+func (b *batcher) search(index, typ string, body []byte) (Result, error) {
+	req := batchReq{
+		index: index,
+		typ:   typ,
+		body:  body,
+		retCh: make(chan batchRes, 1),
+	}
+
+	b.Lock()
+	b.pending = append(b.pending, req)
+	if len(b.pending) == 1 {
+		time.AfterFunc(b.window, b.flush)
+	}
+	b.Unlock()
+
+	ret := <-req.retCh
+	return ret.res, ret.err
+}
+
+func (b *batcher) flush() {
+	b.Lock()
+	reqs := b.pending
+	b.pending = nil
+	b.Unlock()
+
+	if len(reqs) == 1 {
+		res, err := doSearch(reqs[0].index, reqs[0].typ, reqs[0].body)
+		reqs[0].retCh <- batchRes{res, err}
+		return
+	}
+
+	items, err := doMSearch(reqs)
+	if err != nil {
+		llog.Warn("multi search failed, falling back to individual searches", llog.KV{
+			"numSearches": len(reqs),
+			"err":         err,
+		})
+	}
+
+	for i, req := range reqs {
+		// if the item failed the search is done again on its own, which both
+		// retries it and gets a proper error message if it fails again
+		if err != nil || items[i].err != nil {
+			go func(req batchReq) {
+				res, err := doSearch(req.index, req.typ, req.body)
+				req.retCh <- batchRes{res, err}
+			}(req)
+			continue
+		}
+		req.retCh <- items[i]
+	}
+}
+
+type msearchHeader struct {
+	Index string `json:"index"`
+	Type  string `json:"type,omitempty"`
+}
+
+type msearchItem struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// mSearchBody builds the newline-delimited body of a multi search request
+func mSearchBody(reqs []batchReq) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	for _, req := range reqs {
+		h := msearchHeader{Index: req.index}
+		if !Version().typeless() {
+			h.Type = req.typ
+		}
+		hb, err := json.Marshal(h)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(hb)
+		buf.WriteByte('\n')
+		// each body must be on a single line
+		if err := json.Compact(buf, req.body); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// doMSearch performs the given searches in a single multi search request,
+// returning a result (or error) for each one in the same order
+func doMSearch(reqs []batchReq) ([]batchRes, error) {
+	bodyReq, err := mSearchBody(reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	code, body, err := pool.request("POST", "/_msearch", bodyReq, true)
+	if err != nil {
+		return nil, err
+	} else if code != 200 {
+		return nil, fmt.Errorf("non-200 response from multi search: %d", code)
+	}
+
+	var resp struct {
+		Responses []json.RawMessage `json:"responses"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	} else if len(resp.Responses) != len(reqs) {
+		return nil, fmt.Errorf("multi search returned %d responses for %d searches", len(resp.Responses), len(reqs))
+	}
+
+	items := make([]batchRes, len(reqs))
+	for i, raw := range resp.Responses {
+		var item msearchItem
+		if err := json.Unmarshal(raw, &item); err != nil {
+			items[i].err = err
+		} else if len(item.Error) > 0 {
+			items[i].err = fmt.Errorf("search failed in multi search: %s", item.Error)
+		} else {
+			items[i].res, items[i].err = decodeResult(200, raw)
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,110 @@
+package search
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBatchServer returns a server which responds to multi searches with a
+// result for each search whose HitCount is the length of its index name, or an
+// error for the index "bad". Individual searches on any index return a HitCount
+// of 100. Malformed multi search bodies are recorded in errs, since failing the
+// test from the handler's goroutine doesn't work
+func testBatchServer(msearches, searches *int, errs *[]error) (*httptest.Server, string) {
+	var l sync.Mutex
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_msearch", func(w http.ResponseWriter, r *http.Request) {
+		l.Lock()
+		defer l.Unlock()
+		*msearches++
+
+		var responses []string
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			var h msearchHeader
+			if err := json.Unmarshal(sc.Bytes(), &h); err != nil {
+				*errs = append(*errs, err)
+			} else if !sc.Scan() {
+				*errs = append(*errs, fmt.Errorf("no body for header %q", sc.Text()))
+			}
+			if h.Index == "bad" {
+				responses = append(responses, `{"status":400,"error":{"type":"wat"}}`)
+			} else {
+				responses = append(responses, fmt.Sprintf(`{"status":200,"hits":{"total":%d}}`, len(h.Index)))
+			}
+		}
+		fmt.Fprintf(w, `{"responses":[%s]}`, strings.Join(responses, ","))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		l.Lock()
+		*searches++
+		l.Unlock()
+		fmt.Fprint(w, `{"hits":{"total":100}}`)
+	})
+	s := httptest.NewServer(mux)
+	return s, strings.TrimPrefix(s.URL, "http://")
+}
+
+func TestBatcher(t *T) {
+	var msearches, searches int
+	var handlerErrs []error
+	s, addr := testBatchServer(&msearches, &searches, &handlerErrs)
+	defer s.Close()
+
+	oldPool := pool
+	defer func() { pool = oldPool }()
+	pool = newNodePool([]string{addr}, 0)
+
+	b := &batcher{window: 50 * time.Millisecond}
+	indices := []string{"a", "bb", "ccc", "bad"}
+	results := make([]Result, len(indices))
+	errs := make([]error, len(indices))
+	var wg sync.WaitGroup
+	for i := range indices {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = b.search(indices[i], "", []byte(`{}`))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Empty(t, handlerErrs)
+	assert.Equal(t, 1, msearches)
+	for i := range indices {
+		require.Nil(t, errs[i])
+	}
+	assert.Equal(t, uint64(1), results[0].HitCount)
+	assert.Equal(t, uint64(2), results[1].HitCount)
+	assert.Equal(t, uint64(3), results[2].HitCount)
+
+	// the failed item falls back to an individual search
+	assert.Equal(t, 1, searches)
+	assert.Equal(t, uint64(100), results[3].HitCount)
+
+	// a lone search doesn't bother with a multi search
+	res, err := b.search("a", "", []byte(`{}`))
+	require.Nil(t, err)
+	assert.Equal(t, uint64(100), res.HitCount)
+	assert.Equal(t, 1, msearches)
+	assert.Equal(t, 2, searches)
+}
+
+func TestMSearchBody(t *T) {
+	body, err := mSearchBody([]batchReq{
+		{index: "foo", typ: "bar", body: []byte(`{"size":0}`)},
+		{index: "baz", body: []byte("{\n\"size\":1\n}\n")},
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "{\"index\":\"foo\",\"type\":\"bar\"}\n{\"size\":0}\n{\"index\":\"baz\"}\n{\"size\":1}\n", string(body))
+}
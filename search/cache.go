@@ -0,0 +1,140 @@
+package search
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/levenlabs/go-llog"
+	"github.com/levenlabs/thumper/config"
+)
+
+// The name of the elasticsearch datasource, used as part of the cache keys of
+// its searches
+const cacheDatasource = "elasticsearch"
+
+// How often the cache's hit rate is logged and expired entries are cleaned up
+const cacheReportInterval = time.Minute
+
+// Exported through expvar, so they can be scraped if --stats-addr is set
+var (
+	cacheHits   = expvar.NewInt("searchCacheHits")
+	cacheMisses = expvar.NewInt("searchCacheMisses")
+)
+
+type cacheEntry struct {
+	done    chan struct{} // closed once res and err are filled in
+	res     Result
+	err     error
+	expires time.Time
+}
+
+// resultCache caches the results of searches for a short time, so that alerts
+// making identical searches at around the same time only cause the search to
+// be performed once. Identical searches made while the first is still in
+// progress wait on its result rather than performing their own
+type resultCache struct {
+	sync.Mutex
+	ttl time.Duration
+	m   map[string]*cacheEntry
+}
+
+// cache is nil if caching is disabled
+var cache *resultCache
+
+func init() {
+	if config.SearchCacheTTL > 0 {
+		cache = newResultCache(config.SearchCacheTTL)
+		go cache.reportSpin()
+	}
+}
+
+func newResultCache(ttl time.Duration) *resultCache {
+	return &resultCache{
+		ttl: ttl,
+		m:   map[string]*cacheEntry{},
+	}
+}
+
+// cacheKey combines the given parts, which should describe everything that
+// makes a search unique (the index, type, body, etc...), into a key. The parts
+// may contain credentials (headers, dsns, etc...), and keys get logged, so
+// they're hashed. Only the first part, generally the datasource, is kept as-is
+func cacheKey(first string, parts ...string) string {
+	h := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return first + ":" + hex.EncodeToString(h[:])
+}
+
+// cached returns the cached Result for the given key if there is one,
+// otherwise it calls fn and caches what it returns. Errors are not cached
+func cached(key string, fn func() (Result, error)) (Result, error) {
+	if cache == nil {
+		return fn()
+	}
+	return cache.get(key, fn)
+}
+
+func (c *resultCache) get(key string, fn func() (Result, error)) (Result, error) {
+	c.Lock()
+	e, ok := c.m[key]
+	if ok && (e.expires.IsZero() || time.Now().Before(e.expires)) {
+		c.Unlock()
+		cacheHits.Add(1)
+		llog.Debug("search cache hit", llog.KV{"key": key})
+		<-e.done
+		return e.res, e.err
+	}
+
+	e = &cacheEntry{done: make(chan struct{})}
+	c.m[key] = e
+	c.Unlock()
+	cacheMisses.Add(1)
+	llog.Debug("search cache miss", llog.KV{"key": key})
+
+	e.res, e.err = fn()
+
+	c.Lock()
+	if e.err != nil {
+		delete(c.m, key)
+	} else {
+		e.expires = time.Now().Add(c.ttl)
+	}
+	c.Unlock()
+	close(e.done)
+	return e.res, e.err
+}
+
+// clean removes all expired entries from the cache
+func (c *resultCache) clean() {
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	for key, e := range c.m {
+		if !e.expires.IsZero() && !now.Before(e.expires) {
+			delete(c.m, key)
+		}
+	}
+}
+
+func (c *resultCache) reportSpin() {
+	var lastHits, lastMisses int64
+	for range time.Tick(cacheReportInterval) {
+		c.clean()
+
+		hits, misses := cacheHits.Value(), cacheMisses.Value()
+		newHits, newMisses := hits-lastHits, misses-lastMisses
+		lastHits, lastMisses = hits, misses
+		if newHits+newMisses == 0 {
+			continue
+		}
+
+		llog.Info("search cache stats", llog.KV{
+			"hits":    newHits,
+			"misses":  newMisses,
+			"hitRate": float64(newHits) / float64(newHits+newMisses),
+		})
+	}
+}
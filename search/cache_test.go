@@ -0,0 +1,97 @@
+package search
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultCache(t *T) {
+	c := newResultCache(50 * time.Millisecond)
+	var calls int64
+	fn := func() (Result, error) {
+		atomic.AddInt64(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return Result{HitInfo: HitInfo{HitCount: 5}}, nil
+	}
+
+	// concurrent identical searches should only be performed once
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := c.get("a", fn)
+			assert.Nil(t, err)
+			assert.Equal(t, uint64(5), res.HitCount)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(1), atomic.LoadInt64(&calls))
+
+	_, err := c.get("b", fn)
+	require.Nil(t, err)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&calls))
+
+	// once expired the search is performed again
+	time.Sleep(60 * time.Millisecond)
+	c.clean()
+	assert.Empty(t, c.m)
+	_, err = c.get("a", fn)
+	require.Nil(t, err)
+	assert.Equal(t, int64(3), atomic.LoadInt64(&calls))
+}
+
+func TestCacheKey(t *T) {
+	k := cacheKey("http", "GET", "http://example.com", "map[Authorization:Bearer secret]")
+	assert.True(t, strings.HasPrefix(k, "http:"))
+	assert.NotContains(t, k, "secret")
+	assert.Equal(t, k, cacheKey("http", "GET", "http://example.com", "map[Authorization:Bearer secret]"))
+	assert.NotEqual(t, k, cacheKey("http", "GET", "http://example.com", "map[Authorization:Bearer other]"))
+	// parts are separated, so moving characters between them changes the key
+	assert.NotEqual(t, cacheKey("a", "bc", "d"), cacheKey("a", "b", "cd"))
+}
+
+func TestResultCacheError(t *T) {
+	c := newResultCache(time.Minute)
+	var calls int
+	fn := func() (Result, error) {
+		calls++
+		return Result{}, errors.New("failed")
+	}
+
+	_, err := c.get("a", fn)
+	assert.NotNil(t, err)
+	_, err = c.get("a", fn)
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestSearchCached(t *T) {
+	s, addr := testServer(200, `{"hits":{"total":3,"hits":[]}}`)
+	defer s.Close()
+	oldPool, oldCache := pool, cache
+	defer func() { pool, cache = oldPool, oldCache }()
+	pool = newNodePool([]string{addr}, 0)
+	cache = newResultCache(time.Minute)
+
+	res, err := Search("foo", "", map[string]interface{}{"size": 0})
+	require.Nil(t, err)
+	assert.Equal(t, uint64(3), res.HitCount)
+
+	// served from the cache even though the server is gone
+	s.Close()
+	res, err = Search("foo", "", map[string]interface{}{"size": 0})
+	require.Nil(t, err)
+	assert.Equal(t, uint64(3), res.HitCount)
+
+	// a different index isn't
+	_, err = Search("bar", "", map[string]interface{}{"size": 0})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,98 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The modes a search can be performed in. ModeSearch is the default, and
+// returns the search's hits as usual. ModeCount only returns the number of
+// documents matching the search's query, and ModeAggregations only returns the
+// search's aggregations and HitCount. Both are cheaper for the cluster than a
+// normal search
+const (
+	ModeSearch       = "search"
+	ModeCount        = "count"
+	ModeAggregations = "aggregations"
+)
+
+// ValidMode returns whether the given mode is one of the known ones. The empty
+// string is treated as ModeSearch
+func ValidMode(mode string) bool {
+	switch mode {
+	case "", ModeSearch, ModeCount, ModeAggregations:
+		return true
+	}
+	return false
+}
+
+func countPath(index, typ string) string {
+	if typ != "" && !Version().typeless() {
+		return fmt.Sprintf("/%s/%s/_count", index, typ)
+	}
+	return fmt.Sprintf("/%s/_count", index)
+}
+
+// Count uses the count api to find the number of documents matching the given
+// search. Only the query portion of the search is used, and only the HitCount
+// of the returned Result is filled in
+func Count(index, typ string, search interface{}) (Result, error) {
+	body, err := toMap(search)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// the count api doesn't accept anything besides the query
+	countBody := map[string]interface{}{}
+	if q, ok := body["query"]; ok {
+		countBody["query"] = q
+	}
+	bodyReq, err := json.Marshal(countBody)
+	if err != nil {
+		return Result{}, err
+	}
+
+	key := cacheKey(cacheDatasource, "count", index, typ, string(bodyReq))
+	return cached(key, func() (Result, error) {
+		return doCount(index, typ, bodyReq)
+	})
+}
+
+func doCount(index, typ string, bodyReq []byte) (Result, error) {
+	code, bodyResp, err := pool.request("GET", countPath(index, typ), bodyReq, true)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// decodeResult is used to get the error handling, the count itself is
+	// decoded separately
+	if _, err := decodeResult(code, bodyResp); err != nil {
+		return Result{}, err
+	}
+
+	var count struct {
+		Count  uint64    `json:"count"`
+		Shards ShardInfo `json:"_shards"`
+	}
+	if err := json.Unmarshal(bodyResp, &count); err != nil {
+		return Result{}, err
+	}
+	return Result{
+		HitInfo: HitInfo{
+			HitCount:         count.Count,
+			HitCountRelation: "eq",
+		},
+		Shards: count.Shards,
+	}, nil
+}
+
+// SearchAggregations is like Search, except that no hits are returned, only
+// the HitCount and Aggregations
+func SearchAggregations(index, typ string, search interface{}) (Result, error) {
+	body, err := toMap(search)
+	if err != nil {
+		return Result{}, err
+	}
+	body["size"] = 0
+	return Search(index, typ, body)
+}
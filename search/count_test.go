@@ -0,0 +1,55 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCount(t *T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+
+		switch r.URL.Path {
+		case "/foo/_count":
+			// everything but the query should have been stripped out
+			assert.Equal(t, map[string]interface{}{
+				"query": map[string]interface{}{"match_all": map[string]interface{}{}},
+			}, body)
+			fmt.Fprint(w, `{"count":42,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0}}`)
+		case "/foo/_search":
+			assert.Equal(t, float64(0), body["size"])
+			fmt.Fprint(w, `{"took":1,"hits":{"total":42,"hits":[]},"aggregations":{"a":{"value":1}}}`)
+		default:
+			t.Errorf("unexpected request: %s", r.URL)
+			w.WriteHeader(400)
+		}
+	}))
+	defer s.Close()
+
+	oldPool := pool
+	defer func() { pool = oldPool }()
+	pool = newNodePool([]string{strings.TrimPrefix(s.URL, "http://")}, 0)
+
+	search := Dict{
+		"query": Dict{"match_all": Dict{}},
+		"size":  10,
+		"aggs":  Dict{"a": Dict{"max": Dict{"field": "b"}}},
+	}
+	res, err := Count("foo", "", search)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(42), res.HitCount)
+	assert.Equal(t, "eq", res.HitCountRelation)
+
+	res, err = SearchAggregations("foo", "", search)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(42), res.HitCount)
+	assert.Equal(t, map[string]interface{}{"value": float64(1)}, res.Aggregations["a"])
+}
@@ -0,0 +1,90 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// Query describes a single search to be performed against a Datasource. All of
+// its fields have already had their templates rendered. Which of the fields
+// are used depends on the Datasource
+type Query struct {
+	Index    string
+	Type     string
+	Body     Dict
+	SQL      string
+	PPL      string
+	PromQL   string
+	Mode     string
+	Paginate Pagination
+
+	// Identifies the alert search the Query is for. Used by datasources which
+	// keep state between runs
+	Name string
+
+	// The time the search is being performed for, i.e. the time the alert was
+	// scheduled for. Only used by datasources which query at a point in time
+	Time time.Time
+}
+
+// Datasource describes something which searches can be performed against.
+// Elasticsearch is the default, but alerts may use any of them
+type Datasource interface {
+
+	// Search performs the given Query, returning its Result
+	Search(Query) (Result, error)
+}
+
+// ToDatasource takes in a datasource definition, looks at its "type" key, and
+// any other fields necessary based on that type, and returns a Datasource (or
+// an error). An empty definition returns the Elasticsearch datasource
+func ToDatasource(in map[string]interface{}) (Datasource, error) {
+	var ds Datasource
+	typ, _ := in["type"].(string)
+	typ = strings.ToLower(typ)
+	switch typ {
+	case "", "elasticsearch":
+		ds = &Elasticsearch{}
+	case "http":
+		ds = &HTTP{}
+	case "sql":
+		ds = &SQLDatabase{}
+	case "prometheus":
+		ds = &Prometheus{}
+	case "file":
+		ds = &File{}
+	default:
+		return nil, fmt.Errorf("unknown datasource type: %q", typ)
+	}
+
+	if err := mapstructure.Decode(in, ds); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// Elasticsearch is the datasource for the elasticsearch (or opensearch)
+// cluster thumper was configured with. It uses all of the fields of a Query
+type Elasticsearch struct{}
+
+// Search performs the Query against the cluster. If SQL or PPL is set it's
+// used, otherwise the Body is performed as a search in the given Mode
+func (Elasticsearch) Search(q Query) (Result, error) {
+	switch {
+	case q.SQL != "":
+		return SQL(q.SQL, q.Paginate)
+	case q.PPL != "":
+		return PPL(q.PPL)
+	case q.Mode == ModeCount:
+		return Count(q.Index, q.Type, q.Body)
+	case q.Mode == ModeAggregations:
+		return SearchAggregations(q.Index, q.Type, q.Body)
+	case q.Paginate.MaxHits > 0:
+		return SearchAll(q.Index, q.Type, q.Body, q.Paginate)
+	default:
+		return Search(q.Index, q.Type, q.Body)
+	}
+}
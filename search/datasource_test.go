@@ -0,0 +1,63 @@
+package search
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToDatasource(t *T) {
+	ds, err := ToDatasource(nil)
+	require.Nil(t, err)
+	assert.Equal(t, &Elasticsearch{}, ds)
+
+	ds, err = ToDatasource(map[string]interface{}{"type": "elasticsearch"})
+	require.Nil(t, err)
+	assert.Equal(t, &Elasticsearch{}, ds)
+
+	ds, err = ToDatasource(map[string]interface{}{
+		"type":    "HTTP",
+		"url":     "http://example.com",
+		"headers": map[string]interface{}{"X-Foo": "bar"},
+	})
+	require.Nil(t, err)
+	assert.Equal(t, &HTTP{
+		URL:     "http://example.com",
+		Headers: map[string]string{"X-Foo": "bar"},
+	}, ds)
+
+	_, err = ToDatasource(map[string]interface{}{"type": "wat"})
+	assert.NotNil(t, err)
+}
+
+func TestElasticsearchDatasource(t *T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/foo/_count":
+			fmt.Fprint(w, `{"count":7}`)
+		case "/foo/_search":
+			fmt.Fprint(w, `{"hits":{"total":3,"hits":[]}}`)
+		default:
+			t.Fatalf("unexpected request: %s", r.URL)
+		}
+	}))
+	defer s.Close()
+
+	oldPool := pool
+	defer func() { pool = oldPool }()
+	pool = newNodePool([]string{strings.TrimPrefix(s.URL, "http://")}, 0)
+
+	var ds Elasticsearch
+	res, err := ds.Search(Query{Index: "foo", Body: Dict{}})
+	require.Nil(t, err)
+	assert.Equal(t, uint64(3), res.HitCount)
+
+	res, err = ds.Search(Query{Index: "foo", Body: Dict{}, Mode: ModeCount})
+	require.Nil(t, err)
+	assert.Equal(t, uint64(7), res.HitCount)
+}
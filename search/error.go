@@ -0,0 +1,120 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ErrorCause describes a single cause of an error returned by elasticsearch
+type ErrorCause struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+	Index  string `json:"index"`
+}
+
+// ShardFailure describes a search failing on a single shard
+type ShardFailure struct {
+	Index  string     `json:"index"`
+	Shard  int        `json:"shard"`
+	Node   string     `json:"node"`
+	Reason ErrorCause `json:"reason"`
+}
+
+// ShardInfo describes how many shards a search was performed on, and how many
+// of them failed
+type ShardInfo struct {
+	Total      int            `json:"total"`
+	Successful int            `json:"successful"`
+	Skipped    int            `json:"skipped"`
+	Failed     int            `json:"failed"`
+	Failures   []ShardFailure `json:"failures"`
+}
+
+// Error describes an error returned by elasticsearch. Errors which come from
+// elsewhere (e.g. the cluster couldn't be reached) only have Reason set, see
+// AsError
+type Error struct {
+	Status        int    // The http status code of the response, if there was one
+	Type          string // e.g. "search_phase_execution_exception"
+	Reason        string
+	RootCauses    []ErrorCause
+	ShardFailures []ShardFailure
+}
+
+func (e *Error) Error() string {
+	s := e.Reason
+	if e.Type != "" {
+		s = e.Type + ": " + s
+	}
+	if len(e.RootCauses) > 0 && e.RootCauses[0].Reason != e.Reason {
+		s += fmt.Sprintf(" (root cause: %s: %s)", e.RootCauses[0].Type, e.RootCauses[0].Reason)
+	} else if len(e.ShardFailures) > 0 && e.ShardFailures[0].Reason.Reason != e.Reason {
+		s += fmt.Sprintf(" (shard failure: %s: %s)", e.ShardFailures[0].Reason.Type, e.ShardFailures[0].Reason.Reason)
+	}
+	return s
+}
+
+// AsError returns the Error the given error describes. If it isn't an *Error
+// then only the Reason of the returned Error is filled in
+func AsError(err error) Error {
+	if e, ok := err.(*Error); ok {
+		return *e
+	}
+	return Error{Reason: err.Error()}
+}
+
+// errorBody covers the different formats elasticsearch has used for the body
+// of error responses over time. The error field is a string in very old
+// versions and an object in all others
+type errorBody struct {
+	Error  json.RawMessage `json:"error"`
+	Status int             `json:"status"`
+	Reason string          `json:"reason"`
+}
+
+// parseError decodes the body of a non-200 response into an Error. If the body
+// can't be decoded the Error is still returned, with a generic Reason
+func parseError(code int, body []byte) *Error {
+	e := &Error{Status: code}
+
+	var eb errorBody
+	if err := json.Unmarshal(body, &eb); err == nil {
+		var obj struct {
+			ErrorCause
+			RootCause    []ErrorCause   `json:"root_cause"`
+			FailedShards []ShardFailure `json:"failed_shards"`
+		}
+		if json.Unmarshal(eb.Error, &obj) == nil {
+			e.Type, e.Reason = obj.Type, obj.Reason
+			e.RootCauses, e.ShardFailures = obj.RootCause, obj.FailedShards
+		} else {
+			json.Unmarshal(eb.Error, &e.Reason)
+		}
+		if e.Reason == "" {
+			e.Reason = eb.Reason
+		}
+	}
+
+	if e.Reason == "" && len(e.RootCauses) > 0 {
+		e.Reason = e.RootCauses[0].Reason
+	}
+	if e.Reason == "" {
+		e.Reason = fmt.Sprintf("non-200 response code returned: %d", code)
+	}
+	return e
+}
+
+// ShardError returns an *Error describing the Result's shard failures, or nil
+// if no shards failed. Elasticsearch returns a successful response when only
+// some shards fail, so by default those failures are ignored
+func (r Result) ShardError() error {
+	if r.Shards.Failed == 0 {
+		return nil
+	}
+	return &Error{
+		Status:        200,
+		Type:          "shard_failures",
+		Reason:        fmt.Sprintf("%d of %d shards failed", r.Shards.Failed, r.Shards.Total),
+		ShardFailures: r.Shards.Failures,
+	}
+}
@@ -0,0 +1,83 @@
+package search
+
+import (
+	"encoding/json"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseError(t *T) {
+	body := `{
+		"error": {
+			"root_cause": [
+				{"type":"query_shard_exception","reason":"failed to create query: foo","index":"logs-1"}
+			],
+			"type": "search_phase_execution_exception",
+			"reason": "all shards failed",
+			"phase": "query",
+			"grouped": true,
+			"failed_shards": [
+				{
+					"shard": 0,
+					"index": "logs-1",
+					"node": "abc",
+					"reason": {"type":"query_shard_exception","reason":"failed to create query: foo"}
+				}
+			]
+		},
+		"status": 400
+	}`
+	e := parseError(400, []byte(body))
+	assert.Equal(t, &Error{
+		Status: 400,
+		Type:   "search_phase_execution_exception",
+		Reason: "all shards failed",
+		RootCauses: []ErrorCause{
+			{Type: "query_shard_exception", Reason: "failed to create query: foo", Index: "logs-1"},
+		},
+		ShardFailures: []ShardFailure{
+			{
+				Index:  "logs-1",
+				Shard:  0,
+				Node:   "abc",
+				Reason: ErrorCause{Type: "query_shard_exception", Reason: "failed to create query: foo"},
+			},
+		},
+	}, e)
+	assert.Equal(t, "search_phase_execution_exception: all shards failed (root cause: query_shard_exception: failed to create query: foo)", e.Error())
+
+	// very old versions of elasticsearch return the error as a string
+	e = parseError(404, []byte(`{"error":"IndexMissingException[[foo] missing]","status":404}`))
+	assert.Equal(t, &Error{Status: 404, Reason: "IndexMissingException[[foo] missing]"}, e)
+
+	e = parseError(502, []byte(`<html>Bad Gateway</html>`))
+	assert.Equal(t, &Error{Status: 502, Reason: "non-200 response code returned: 502"}, e)
+}
+
+func TestShardError(t *T) {
+	var res Result
+	require.Nil(t, json.Unmarshal([]byte(`{
+		"hits": {"total": 1, "hits": []},
+		"_shards": {
+			"total": 5, "successful": 4, "skipped": 0, "failed": 1,
+			"failures": [
+				{"shard":3,"index":"logs-1","node":"abc","reason":{"type":"illegal_argument_exception","reason":"bad field"}}
+			]
+		}
+	}`), &res))
+	assert.Equal(t, 5, res.Shards.Total)
+	assert.Equal(t, 1, res.Shards.Failed)
+
+	err := res.ShardError()
+	require.NotNil(t, err)
+	e := AsError(err)
+	assert.Equal(t, "shard_failures", e.Type)
+	assert.Equal(t, "1 of 5 shards failed", e.Reason)
+	require.Len(t, e.ShardFailures, 1)
+	assert.Equal(t, "bad field", e.ShardFailures[0].Reason.Reason)
+
+	res.Shards = ShardInfo{Total: 5, Successful: 5}
+	assert.Nil(t, res.ShardError())
+}
@@ -0,0 +1,349 @@
+package search
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/levenlabs/go-llog"
+)
+
+const defaultFileMaxHits = 10
+
+// fileTail is a file being tailed by the file datasource, along with how far
+// into it has been read. The file is kept open so that if it's rotated away the
+// rest of it can still be read
+type fileTail struct {
+	path string
+	f    *os.File
+
+	// offset is how far into the file the lines have been handled by a
+	// successful run, next is how far the latest read got
+	offset int64
+	next   int64
+}
+
+// tailState is all the files being tailed for a single alert search. Files
+// which have been rotated away are kept in rotated until a run which read the
+// rest of them succeeds
+type tailState struct {
+	sync.Mutex
+	name    string
+	init    bool
+	tails   []*fileTail
+	rotated []*fileTail
+}
+
+var (
+	tailStatesL sync.Mutex
+	tailStates  = map[string]*tailState{}
+)
+
+func getTailState(name, path string) *tailState {
+	key := cacheKey(name, path)
+	tailStatesL.Lock()
+	defer tailStatesL.Unlock()
+	ts, ok := tailStates[key]
+	if !ok {
+		ts = &tailState{name: name}
+		tailStates[key] = ts
+	}
+	return ts
+}
+
+// CommitFile marks the lines returned by the latest file datasource searches
+// with the given Query Name as handled. Until it's called later searches
+// return the same lines again, so that they aren't lost if the alert run they
+// were returned to fails
+func CommitFile(name string) {
+	tailStatesL.Lock()
+	defer tailStatesL.Unlock()
+	for _, ts := range tailStates {
+		if ts.name == name {
+			ts.commit()
+		}
+	}
+}
+
+// File is a datasource which reads the lines written to a set of local files
+// since the previous run, and returns those matching the given filters as
+// Hits. Each Hit's Index is the file the line came from, and its Source is the
+// decoded line if Format is json, otherwise an object with the line as its
+// message field. Any named groups in Match are added to the Source as well
+type File struct {
+	Path   string `mapstructure:"path"`   // May be a glob, e.g. /var/log/app/*.log
+	Format string `mapstructure:"format"` // "plain" (the default) or "json"
+
+	// If set, only lines matching this regex are returned
+	Match string `mapstructure:"match"`
+
+	// If set, only lines matching this query are returned. See parseQuery for
+	// the syntax
+	Query string `mapstructure:"query"`
+
+	// The maximum number of matching lines to return as Hits, HitCount is the
+	// total number of matches regardless. Defaults to 10
+	MaxHits int `mapstructure:"max_hits"`
+
+	// Where to start reading files which already exist the first time the
+	// datasource is used, either "end" (the default) or "beginning". Files
+	// which appear after that are always read from the beginning
+	Start string `mapstructure:"start"`
+}
+
+// Search reads the new lines from the files. The Query's Name is used to keep
+// track of where the previous run left off
+func (fl *File) Search(q Query) (Result, error) {
+	if fl.Path == "" {
+		return Result{}, errors.New("no path given for file datasource")
+	} else if fl.Format != "" && fl.Format != "plain" && fl.Format != "json" {
+		return Result{}, fmt.Errorf("unknown file datasource format: %q", fl.Format)
+	} else if fl.Start != "" && fl.Start != "end" && fl.Start != "beginning" {
+		return Result{}, fmt.Errorf("unknown file datasource start: %q", fl.Start)
+	}
+
+	var match *regexp.Regexp
+	if fl.Match != "" {
+		var err error
+		if match, err = regexp.Compile(fl.Match); err != nil {
+			return Result{}, fmt.Errorf("parsing file datasource match: %s", err)
+		}
+	}
+	terms := parseQuery(fl.Query)
+
+	maxHits := fl.MaxHits
+	if maxHits <= 0 {
+		maxHits = defaultFileMaxHits
+	}
+
+	start := time.Now()
+	res := Result{}
+	res.HitCountRelation = "eq"
+	err := fl.readLines(q.Name, func(path, line string) {
+		if match != nil && !match.MatchString(line) {
+			return
+		}
+		src := fl.source(line, match)
+		if !matchQuery(terms, line, src) {
+			return
+		}
+		res.HitCount++
+		if len(res.Hits) < maxHits {
+			res.Hits = append(res.Hits, Hit{Index: path, Source: src})
+		}
+	})
+	res.TookMS = uint64(time.Since(start) / time.Millisecond)
+	return res, err
+}
+
+// source returns the Source of the Hit for a line
+func (fl *File) source(line string, match *regexp.Regexp) map[string]interface{} {
+	var src map[string]interface{}
+	if fl.Format == "json" {
+		if err := json.Unmarshal([]byte(line), &src); err != nil {
+			src = nil
+		}
+	}
+	if src == nil {
+		src = map[string]interface{}{"message": line}
+	}
+
+	if match != nil {
+		sub := match.FindStringSubmatch(line)
+		for i, name := range match.SubexpNames() {
+			if _, ok := src[name]; name != "" && !ok && i < len(sub) {
+				src[name] = sub[i]
+			}
+		}
+	}
+	return src
+}
+
+// readLines calls fn for each complete line written to the datasource's files
+// since the last time CommitFile was called for the same name
+func (fl *File) readLines(name string, fn func(path, line string)) error {
+	ts := getTailState(name, fl.Path)
+	ts.Lock()
+	defer ts.Unlock()
+
+	paths, err := filepath.Glob(fl.Path)
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	var tails []*fileTail
+	seen := map[*fileTail]bool{}
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil || fi.IsDir() {
+			continue
+		}
+
+		t := ts.find(fi)
+		if t == nil {
+			if t, err = openTail(path, fi, ts.init || fl.Start == "beginning"); err != nil {
+				llog.Warn("could not open file for file datasource", llog.KV{"path": path, "err": err})
+				continue
+			}
+		} else if fi.Size() < t.offset {
+			// the file has been truncated, start over from its beginning
+			t.offset = 0
+		}
+		t.path = path
+		seen[t] = true
+		tails = append(tails, t)
+	}
+
+	// files which aren't matched anymore have most likely been rotated away.
+	// Whatever is left in them is read, they're closed once that's committed
+	var rotated []*fileTail
+	for _, t := range append(ts.tails, ts.rotated...) {
+		if seen[t] {
+			continue
+		}
+		if err := t.read(fn); err != nil {
+			llog.Warn("could not read rotated file for file datasource", llog.KV{"path": t.path, "err": err})
+		}
+		rotated = append(rotated, t)
+	}
+	ts.tails = tails
+	ts.rotated = rotated
+	ts.init = true
+
+	for _, t := range tails {
+		if err := t.read(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commit moves the offset of each file up to where the latest read got, and
+// closes the files which have been rotated away
+func (ts *tailState) commit() {
+	ts.Lock()
+	defer ts.Unlock()
+	for _, t := range ts.tails {
+		t.offset = t.next
+	}
+	for _, t := range ts.rotated {
+		t.f.Close()
+	}
+	ts.rotated = nil
+}
+
+// find returns the fileTail for the given file, or nil
+func (ts *tailState) find(fi os.FileInfo) *fileTail {
+	for _, t := range append(ts.tails, ts.rotated...) {
+		if tfi, err := t.f.Stat(); err == nil && os.SameFile(fi, tfi) {
+			return t
+		}
+	}
+	return nil
+}
+
+func openTail(path string, fi os.FileInfo, fromStart bool) (*fileTail, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &fileTail{path: path, f: f}
+	if !fromStart {
+		t.offset = fi.Size()
+	}
+	t.next = t.offset
+	return t, nil
+}
+
+// read calls fn for each complete line after the fileTail's offset, and moves
+// next past them. A trailing partial line is left to be read next time
+func (t *fileTail) read(fn func(path, line string)) error {
+	t.next = t.offset
+	if _, err := t.f.Seek(t.offset, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(t.f)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		t.next += int64(len(line))
+		fn(t.path, strings.TrimRight(line, "\r\n"))
+	}
+}
+
+type queryTerm struct {
+	negate bool
+	field  string // empty if the term matches against the whole line
+	value  *regexp.Regexp
+}
+
+// parseQuery parses a simple query made up of whitespace separated terms, all
+// of which must match for a line to match. A term of the form field:value
+// matches if the field of the line's Source (dots may be used for nested
+// fields) equals value, where value may contain * wildcards. Any other term
+// matches if the line contains it. A term prefixed with - must not match
+func parseQuery(q string) []queryTerm {
+	var terms []queryTerm
+	for _, s := range strings.Fields(q) {
+		var t queryTerm
+		if strings.HasPrefix(s, "-") && len(s) > 1 {
+			t.negate, s = true, s[1:]
+		}
+
+		if i := strings.Index(s, ":"); i > 0 {
+			t.field, s = s[:i], s[i+1:]
+			pattern := strings.Replace(regexp.QuoteMeta(s), `\*`, `.*`, -1)
+			t.value = regexp.MustCompile("^" + pattern + "$")
+		} else {
+			t.value = regexp.MustCompile(regexp.QuoteMeta(s))
+		}
+		terms = append(terms, t)
+	}
+	return terms
+}
+
+func matchQuery(terms []queryTerm, line string, src map[string]interface{}) bool {
+	for _, t := range terms {
+		var ok bool
+		if t.field == "" {
+			ok = t.value.MatchString(line)
+		} else if v, found := lookupField(src, t.field); found {
+			ok = t.value.MatchString(fmt.Sprint(v))
+		}
+		if ok == t.negate {
+			return false
+		}
+	}
+	return true
+}
+
+func lookupField(src map[string]interface{}, field string) (interface{}, bool) {
+	if v, ok := src[field]; ok {
+		return v, true
+	}
+	var v interface{} = src
+	for _, part := range strings.Split(field, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
@@ -0,0 +1,112 @@
+package search
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendFile(t *T, path, s string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	require.Nil(t, err)
+	_, err = f.WriteString(s)
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+}
+
+func messages(res Result) []interface{} {
+	var l []interface{}
+	for _, h := range res.Hits {
+		l = append(l, h.Source["message"])
+	}
+	return l
+}
+
+func TestFilePlain(t *T) {
+	dir, err := ioutil.TempDir("", "thumper")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "ERROR old\n")
+
+	fl := &File{Path: filepath.Join(dir, "*.log"), Match: `^(?P<level>[A-Z]+) `}
+	q := Query{Name: "TestFilePlain"}
+
+	// existing contents are skipped on the first run
+	res, err := fl.Search(q)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(0), res.HitCount)
+	CommitFile(q.Name)
+
+	// partial lines are left until they're completed
+	appendFile(t, path, "ERROR one\nnope\nWARN two\nERROR thr")
+	res, err = fl.Search(q)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(2), res.HitCount)
+	assert.Equal(t, []interface{}{"ERROR one", "WARN two"}, messages(res))
+	assert.Equal(t, path, res.Hits[0].Index)
+	assert.Equal(t, "WARN", res.Hits[1].Source["level"])
+	CommitFile(q.Name)
+
+	// the rest of a rotated file is read along with the new file, and until
+	// that's committed it's all read again
+	appendFile(t, path, "ee\nERROR four\n")
+	require.Nil(t, os.Rename(path, path+".1"))
+	appendFile(t, path, "ERROR five\n")
+	res, err = fl.Search(q)
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{"ERROR three", "ERROR four", "ERROR five"}, messages(res))
+
+	appendFile(t, path, "ERROR six\n")
+	res, err = fl.Search(q)
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{"ERROR three", "ERROR four", "ERROR five", "ERROR six"}, messages(res))
+	CommitFile(q.Name)
+
+	// truncated files are read from the beginning
+	require.Nil(t, os.Truncate(path, 0))
+	appendFile(t, path, "ERROR seven\n")
+	res, err = fl.Search(q)
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{"ERROR seven"}, messages(res))
+	CommitFile(q.Name)
+
+	res, err = fl.Search(q)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(0), res.HitCount)
+}
+
+func TestFileJSON(t *T) {
+	dir, err := ioutil.TempDir("", "thumper")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, `{"level":"error","http":{"status":502},"msg":"upstream failed"}`+"\n")
+	appendFile(t, path, `{"level":"error","http":{"status":404},"msg":"not found"}`+"\n")
+	appendFile(t, path, `{"level":"info","http":{"status":500},"msg":"upstream failed"}`+"\n")
+	appendFile(t, path, "not json upstream\n")
+	appendFile(t, path, `{"level":"error","http":{"status":503},"msg":"upstream slow"}`+"\n")
+
+	fl := &File{
+		Path:    path,
+		Format:  "json",
+		Query:   "level:error http.status:50* -msg:*slow upstream",
+		Start:   "beginning",
+		MaxHits: 1,
+	}
+	res, err := fl.Search(Query{Name: "TestFileJSON"})
+	require.Nil(t, err)
+	assert.Equal(t, uint64(1), res.HitCount)
+	require.Len(t, res.Hits, 1)
+	assert.Equal(t, "upstream failed", res.Hits[0].Source["msg"])
+
+	fl.Query = "upstream"
+	res, err = fl.Search(Query{Name: "TestFileJSON2"})
+	require.Nil(t, err)
+	assert.Equal(t, uint64(4), res.HitCount)
+	assert.Len(t, res.Hits, 1)
+}
@@ -0,0 +1,99 @@
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/levenlabs/go-llog"
+)
+
+const defaultHTTPTimeout = 30 * time.Second
+
+// HTTP is a datasource which performs a single http request and decodes its
+// json response into the Data field of the Result. If the response is a json
+// array then the HitCount is set to its length. Only the Body of the Query is
+// used, and is sent as the json request body if it's not empty
+type HTTP struct {
+	URL     string            `mapstructure:"url"`
+	Method  string            `mapstructure:"method"` // Defaults to GET, or POST if there is a body
+	Headers map[string]string `mapstructure:"headers"`
+	Timeout string            `mapstructure:"timeout"` // Defaults to 30s
+}
+
+// Search performs the http request
+func (h *HTTP) Search(q Query) (Result, error) {
+	if h.URL == "" {
+		return Result{}, fmt.Errorf("no url given for http datasource")
+	}
+
+	timeout := defaultHTTPTimeout
+	if h.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(h.Timeout); err != nil {
+			return Result{}, fmt.Errorf("parsing http datasource timeout: %s", err)
+		}
+	}
+
+	var body []byte
+	method := h.Method
+	if len(q.Body) > 0 {
+		var err error
+		if body, err = json.Marshal(q.Body); err != nil {
+			return Result{}, err
+		}
+		if method == "" {
+			method = "POST"
+		}
+	} else if method == "" {
+		method = "GET"
+	}
+
+	key := cacheKey("http", method, h.URL, fmt.Sprint(h.Headers), string(body))
+	return cached(key, func() (Result, error) {
+		return h.do(method, body, timeout)
+	})
+}
+
+func (h *HTTP) do(method string, body []byte, timeout time.Duration) (Result, error) {
+	r, err := http.NewRequest(method, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return Result{}, err
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r.Header.Set("Accept", "application/json")
+	for k, v := range h.Headers {
+		r.Header.Set(k, v)
+	}
+
+	start := time.Now()
+	resp, err := (&http.Client{Timeout: timeout}).Do(r)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+	bodyResp, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Result{}, err
+	}
+	llog.Debug("http datasource response", llog.KV{"url": h.URL, "status": resp.StatusCode, "body": string(bodyResp)})
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return Result{}, fmt.Errorf("non 2xx response code returned: %d", resp.StatusCode)
+	}
+
+	res := Result{TookMS: uint64(time.Since(start) / time.Millisecond)}
+	if err := json.Unmarshal(bodyResp, &res.Data); err != nil {
+		return Result{}, fmt.Errorf("decoding http datasource response: %s", err)
+	}
+	if l, ok := res.Data.([]interface{}); ok {
+		res.HitCount = uint64(len(l))
+		res.HitCountRelation = "eq"
+	}
+	return res, nil
+}
@@ -0,0 +1,56 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPDatasource(t *T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "bar", r.Header.Get("X-Foo"))
+		switch r.URL.Path {
+		case "/status":
+			assert.Equal(t, "GET", r.Method)
+			fmt.Fprint(w, `{"healthy":false,"checks":{"db":"down"}}`)
+		case "/items":
+			assert.Equal(t, "POST", r.Method)
+			var body map[string]interface{}
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, map[string]interface{}{"since": float64(5)}, body)
+			fmt.Fprint(w, `[{"id":1},{"id":2}]`)
+		default:
+			w.WriteHeader(500)
+		}
+	}))
+	defer s.Close()
+
+	headers := map[string]string{"X-Foo": "bar"}
+	h := &HTTP{URL: s.URL + "/status", Headers: headers}
+	res, err := h.Search(Query{})
+	require.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"healthy": false,
+		"checks":  map[string]interface{}{"db": "down"},
+	}, res.Data)
+	assert.Equal(t, uint64(0), res.HitCount)
+
+	h = &HTTP{URL: s.URL + "/items", Headers: headers}
+	res, err = h.Search(Query{Body: Dict{"since": 5}})
+	require.Nil(t, err)
+	assert.Len(t, res.Data, 2)
+	assert.Equal(t, uint64(2), res.HitCount)
+
+	h = &HTTP{URL: s.URL + "/wat", Headers: headers}
+	_, err = h.Search(Query{})
+	assert.NotNil(t, err)
+
+	h = &HTTP{URL: s.URL + "/status", Timeout: "wat"}
+	_, err = h.Search(Query{})
+	assert.NotNil(t, err)
+}
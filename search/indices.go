@@ -0,0 +1,42 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// ResolveIndex uses the resolve index api to find the names of all indices,
+// aliases and data streams which match the given index pattern (which may
+// contain wildcards)
+func ResolveIndex(pattern string) ([]string, error) {
+	u := fmt.Sprintf("/_resolve/index/%s", url.PathEscape(pattern))
+	code, body, err := pool.request("GET", u, nil, true)
+	if err != nil {
+		return nil, err
+	} else if code == 404 {
+		return nil, nil
+	} else if code != 200 {
+		return nil, parseError(code, body)
+	}
+
+	type named struct {
+		Name string `json:"name"`
+	}
+	var res struct {
+		Indices     []named `json:"indices"`
+		Aliases     []named `json:"aliases"`
+		DataStreams []named `json:"data_streams"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, l := range [][]named{res.Indices, res.Aliases, res.DataStreams} {
+		for _, n := range l {
+			names = append(names, n.Name)
+		}
+	}
+	return names, nil
+}
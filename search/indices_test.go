@@ -0,0 +1,44 @@
+package search
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveIndex(t *T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_resolve/index/logstash-*":
+			fmt.Fprint(w, `{
+				"indices": [
+					{"name": "logstash-2018.01.01", "attributes": ["open"]},
+					{"name": "logstash-2018.01.03", "attributes": ["open"]}
+				],
+				"aliases": [{"name": "logstash-current", "indices": ["logstash-2018.01.03"]}],
+				"data_streams": []
+			}`)
+		default:
+			w.WriteHeader(404)
+			fmt.Fprint(w, `{"error":{"type":"index_not_found_exception","reason":"no such index"},"status":404}`)
+		}
+	}))
+	defer s.Close()
+
+	oldPool := pool
+	defer func() { pool = oldPool }()
+	pool = newNodePool([]string{strings.TrimPrefix(s.URL, "http://")}, 0)
+
+	names, err := ResolveIndex("logstash-*")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"logstash-2018.01.01", "logstash-2018.01.03", "logstash-current"}, names)
+
+	names, err = ResolveIndex("wat")
+	require.Nil(t, err)
+	assert.Empty(t, names)
+}
@@ -0,0 +1,241 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/levenlabs/go-llog"
+)
+
+// Default values for Pagination fields which aren't set
+const (
+	defaultPageSize  = 1000
+	defaultKeepAlive = "1m"
+)
+
+// Pagination describes how to page through all the hits of a search, rather
+// than only getting back the first page of them
+type Pagination struct {
+	// The maximum number of hits to fetch. Pagination is disabled if this is 0
+	MaxHits int `yaml:"max_hits"`
+
+	// How many hits to fetch per request
+	PageSize int `yaml:"page_size"`
+
+	// How long elasticsearch should keep the search context around between
+	// pages, as an elasticsearch time unit (e.g. "1m")
+	KeepAlive string `yaml:"keep_alive"`
+}
+
+func (p Pagination) withDefaults() Pagination {
+	if p.PageSize <= 0 {
+		p.PageSize = defaultPageSize
+	}
+	if p.PageSize > p.MaxHits {
+		p.PageSize = p.MaxHits
+	}
+	if p.KeepAlive == "" {
+		p.KeepAlive = defaultKeepAlive
+	}
+	return p
+}
+
+// supportsPIT returns whether the cluster supports point in time searches,
+// which were added in elasticsearch 7.10
+func (v ClusterVersion) supportsPIT() bool {
+	if v.Flavor != FlavorElasticsearch {
+		return false
+	}
+	return v.Major > 7 || (v.Major == 7 && v.Minor >= 10)
+}
+
+// supportsShardDoc returns whether the cluster supports sorting point in time
+// searches on _shard_doc, which was added in elasticsearch 7.12. Before that
+// hits of point in time searches without a sort have no sort values to page
+// with
+func (v ClusterVersion) supportsShardDoc() bool {
+	return v.supportsPIT() && (v.Major > 7 || v.Minor >= 12)
+}
+
+// pageInfo holds the fields of a page's response needed to fetch the next one
+type pageInfo struct {
+	PitID    string `json:"pit_id"`
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []struct {
+			Sort []interface{} `json:"sort"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// searchPage performs a single page request, decoding both its result and the
+// info needed to get the next page
+func searchPage(method, path string, body interface{}) (Result, pageInfo, error) {
+	bodyReq, err := json.Marshal(body)
+	if err != nil {
+		return Result{}, pageInfo{}, err
+	}
+
+	code, bodyResp, err := pool.request(method, path, bodyReq, true)
+	if err != nil {
+		return Result{}, pageInfo{}, err
+	}
+
+	res, err := decodeResult(code, bodyResp)
+	if err != nil {
+		return res, pageInfo{}, err
+	}
+
+	var info pageInfo
+	err = json.Unmarshal(bodyResp, &info)
+	return res, info, err
+}
+
+// appendPage adds a page's hits to the accumulated result, returning false if
+// there are no more pages to get
+func appendPage(res *Result, page Result, first bool, p Pagination) bool {
+	if first {
+		*res = page
+	} else {
+		res.TookMS += page.TookMS
+		res.TimedOut = res.TimedOut || page.TimedOut
+		res.Hits = append(res.Hits, page.Hits...)
+	}
+
+	if len(res.Hits) >= p.MaxHits {
+		res.Hits = res.Hits[:p.MaxHits]
+		return false
+	}
+	return len(page.Hits) == p.PageSize
+}
+
+// toMap returns a copy of the given search body as a map which can be freely
+// modified
+func toMap(search interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(search)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	if m == nil {
+		m = map[string]interface{}{}
+	}
+	return m, nil
+}
+
+// SearchAll is like Search, except it pages through the search's hits until
+// all of them, or the given maximum number, have been fetched. On clusters
+// which support it this is done with search_after and a point in time (on
+// elasticsearch 7.10 and 7.11 only if the search is sorted), otherwise the
+// scroll api is used. All other fields of the Result are taken
+// from the first page
+func SearchAll(index, typ string, search interface{}, p Pagination) (Result, error) {
+	body, err := toMap(search)
+	if err != nil {
+		return Result{}, err
+	}
+
+	p = p.withDefaults()
+	body["size"] = p.PageSize
+	bodyReq, err := json.Marshal(body)
+	if err != nil {
+		return Result{}, err
+	}
+
+	key := cacheKey(cacheDatasource, "all", index, typ, string(bodyReq), strconv.Itoa(p.MaxHits))
+	return cached(key, func() (Result, error) {
+		v := Version()
+		if _, sorted := body["sort"]; v.supportsShardDoc() || (sorted && v.supportsPIT()) {
+			return searchAllPIT(index, body, p)
+		}
+		return searchAllScroll(index, typ, body, p)
+	})
+}
+
+func searchAllPIT(index string, body map[string]interface{}, p Pagination) (Result, error) {
+	u := fmt.Sprintf("/%s/_pit?keep_alive=%s", index, p.KeepAlive)
+	code, bodyResp, err := pool.request("POST", u, nil, true)
+	if err != nil {
+		return Result{}, err
+	} else if code != 200 {
+		return Result{}, fmt.Errorf("non-200 response opening point in time: %d", code)
+	}
+
+	var pit struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(bodyResp, &pit); err != nil {
+		return Result{}, err
+	}
+	defer func() {
+		closeBody, _ := json.Marshal(map[string]string{"id": pit.ID})
+		if _, _, err := pool.request("DELETE", "/_pit", closeBody, true); err != nil {
+			llog.Warn("failed to close point in time", llog.KV{"err": err})
+		}
+	}()
+
+	// the index can't be given along with a point in time, since it's implied
+	// by it. _shard_doc is the most efficient sort for paging if none was
+	// given, otherwise it's implicitly used as a tiebreaker. Clusters which
+	// don't support it only get here with a sort
+	if _, ok := body["sort"]; !ok && Version().supportsShardDoc() {
+		body["sort"] = []string{"_shard_doc"}
+	}
+
+	var res Result
+	for first := true; ; first = false {
+		body["pit"] = map[string]string{"id": pit.ID, "keep_alive": p.KeepAlive}
+		page, info, err := searchPage("POST", "/_search", body)
+		if err != nil {
+			return res, err
+		}
+		if info.PitID != "" {
+			pit.ID = info.PitID
+		}
+
+		if !appendPage(&res, page, first, p) || len(info.Hits.Hits) == 0 {
+			return res, nil
+		}
+		body["search_after"] = info.Hits.Hits[len(info.Hits.Hits)-1].Sort
+		delete(body, "aggs")
+		delete(body, "aggregations")
+	}
+}
+
+func searchAllScroll(index, typ string, body map[string]interface{}, p Pagination) (Result, error) {
+	u := searchPath(index, typ) + "?scroll=" + p.KeepAlive
+	page, info, err := searchPage("POST", u, body)
+	if err != nil {
+		return page, err
+	}
+	defer func() {
+		closeBody, _ := json.Marshal(map[string][]string{"scroll_id": {info.ScrollID}})
+		if _, _, err := pool.request("DELETE", "/_search/scroll", closeBody, true); err != nil {
+			llog.Warn("failed to clear scroll", llog.KV{"err": err})
+		}
+	}()
+
+	var res Result
+	if !appendPage(&res, page, true, p) {
+		return res, nil
+	}
+
+	for {
+		scrollBody := map[string]string{"scroll": p.KeepAlive, "scroll_id": info.ScrollID}
+		var scrollInfo pageInfo
+		page, scrollInfo, err = searchPage("POST", "/_search/scroll", scrollBody)
+		if err != nil {
+			return res, err
+		}
+		if scrollInfo.ScrollID != "" {
+			info.ScrollID = scrollInfo.ScrollID
+		}
+		if !appendPage(&res, page, false, p) {
+			return res, nil
+		}
+	}
+}
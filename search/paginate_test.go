@@ -0,0 +1,170 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPageServer serves numDocs documents through either point in time or
+// scroll searches, recording the paths of all requests made and the sort of
+// each point in time search. Anything unexpected about a request is recorded
+// in errs, to be checked by the test
+func testPageServer(numDocs int, paths *[]string, sorts *[]interface{}, errs *[]error) (*httptest.Server, string) {
+	page := func(w http.ResponseWriter, from, size int, extra string) {
+		var hits []string
+		for i := from; i < from+size && i < numDocs; i++ {
+			hits = append(hits, fmt.Sprintf(`{"_id":"%d","sort":[%d]}`, i, i))
+		}
+		fmt.Fprintf(w, `{"took":1,%s"hits":{"total":%d,"hits":[%s]}}`, extra, numDocs, strings.Join(hits, ","))
+	}
+
+	scrollPos := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*paths = append(*paths, r.Method+" "+r.URL.RequestURI())
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		size := 0
+		if s, ok := body["size"].(float64); ok {
+			size = int(s)
+		}
+
+		switch {
+		case r.Method == "DELETE":
+		case r.URL.Path == "/foo/_pit":
+			fmt.Fprint(w, `{"id":"pit1"}`)
+		case r.URL.Path == "/_search":
+			if pit := (map[string]interface{}{"id": "pit1", "keep_alive": "1m"}); !reflect.DeepEqual(pit, body["pit"]) {
+				*errs = append(*errs, fmt.Errorf("unexpected pit: %v", body["pit"]))
+			}
+			*sorts = append(*sorts, body["sort"])
+			from := 0
+			if sa, ok := body["search_after"].([]interface{}); ok {
+				from = int(sa[0].(float64)) + 1
+			}
+			page(w, from, size, `"pit_id":"pit1",`)
+		case r.URL.Path == "/foo/_search":
+			if scroll := r.URL.Query().Get("scroll"); scroll != "1m" {
+				*errs = append(*errs, fmt.Errorf("unexpected scroll: %q", scroll))
+			}
+			scrollPos = size
+			page(w, 0, size, `"_scroll_id":"scroll1",`)
+		case r.URL.Path == "/_search/scroll":
+			if body["scroll_id"] != "scroll1" {
+				*errs = append(*errs, fmt.Errorf("unexpected scroll_id: %v", body["scroll_id"]))
+			}
+			page(w, scrollPos, 2, `"_scroll_id":"scroll1",`)
+			scrollPos += 2
+		default:
+			*errs = append(*errs, fmt.Errorf("unexpected request: %s", r.URL))
+			w.WriteHeader(400)
+		}
+	}))
+	return s, strings.TrimPrefix(s.URL, "http://")
+}
+
+func TestSearchAllPIT(t *T) {
+	var paths []string
+	var sorts []interface{}
+	var handlerErrs []error
+	s, addr := testPageServer(5, &paths, &sorts, &handlerErrs)
+	defer s.Close()
+
+	oldPool, oldVersion := pool, version
+	defer func() { pool, version = oldPool, oldVersion }()
+	pool = newNodePool([]string{addr}, 0)
+	version = ClusterVersion{Flavor: FlavorElasticsearch, Major: 7, Minor: 12}
+
+	res, err := SearchAll("foo", "", Dict{}, Pagination{MaxHits: 4, PageSize: 2})
+	require.Nil(t, err)
+	assert.Equal(t, uint64(5), res.HitCount)
+	assert.Equal(t, uint64(2), res.TookMS)
+	require.Len(t, res.Hits, 4)
+	assert.Equal(t, "3", res.Hits[3].ID)
+	assert.Equal(t, []string{
+		"POST /foo/_pit?keep_alive=1m",
+		"POST /_search",
+		"POST /_search",
+		"DELETE /_pit",
+	}, paths)
+
+	assert.Equal(t, []interface{}{
+		[]interface{}{"_shard_doc"},
+		[]interface{}{"_shard_doc"},
+	}, sorts)
+
+	paths = nil
+	res, err = SearchAll("foo", "", Dict{}, Pagination{MaxHits: 10, PageSize: 2})
+	require.Nil(t, err)
+	require.Len(t, res.Hits, 5)
+	assert.Len(t, paths, 5)
+	assert.Empty(t, handlerErrs)
+}
+
+func TestSearchAllPITShardDoc(t *T) {
+	var paths []string
+	var sorts []interface{}
+	var handlerErrs []error
+	s, addr := testPageServer(5, &paths, &sorts, &handlerErrs)
+	defer s.Close()
+
+	oldPool, oldVersion := pool, version
+	defer func() { pool, version = oldPool, oldVersion }()
+	pool = newNodePool([]string{addr}, 0)
+
+	// _shard_doc doesn't exist before 7.12, so sorted searches keep their own
+	// sort and unsorted ones fall back to scrolling
+	for _, minor := range []int{10, 11} {
+		version = ClusterVersion{Flavor: FlavorElasticsearch, Major: 7, Minor: minor}
+		paths, sorts = nil, nil
+		_, err := SearchAll("foo", "", Dict{"sort": []string{"@timestamp"}}, Pagination{MaxHits: 2, PageSize: 2})
+		require.Nil(t, err)
+		assert.Equal(t, "POST /foo/_pit?keep_alive=1m", paths[0])
+		assert.Equal(t, []interface{}{[]interface{}{"@timestamp"}}, sorts)
+
+		paths, sorts = nil, nil
+		_, err = SearchAll("foo", "", Dict{}, Pagination{MaxHits: 2, PageSize: 2})
+		require.Nil(t, err)
+		assert.Equal(t, "POST /foo/_search?scroll=1m", paths[0])
+		assert.Empty(t, sorts)
+	}
+
+	version = ClusterVersion{Flavor: FlavorElasticsearch, Major: 8, Minor: 0}
+	paths, sorts = nil, nil
+	_, err := SearchAll("foo", "", Dict{}, Pagination{MaxHits: 2, PageSize: 2})
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{[]interface{}{"_shard_doc"}}, sorts)
+	assert.Empty(t, handlerErrs)
+}
+
+func TestSearchAllScroll(t *T) {
+	var paths []string
+	var sorts []interface{}
+	var handlerErrs []error
+	s, addr := testPageServer(5, &paths, &sorts, &handlerErrs)
+	defer s.Close()
+
+	oldPool, oldVersion := pool, version
+	defer func() { pool, version = oldPool, oldVersion }()
+	pool = newNodePool([]string{addr}, 0)
+	version = ClusterVersion{Flavor: FlavorOpenSearch, Major: 2, Minor: 11}
+
+	res, err := SearchAll("foo", "", Dict{}, Pagination{MaxHits: 10, PageSize: 2})
+	require.Nil(t, err)
+	require.Len(t, res.Hits, 5)
+	assert.Equal(t, "4", res.Hits[4].ID)
+	assert.Equal(t, []string{
+		"POST /foo/_search?scroll=1m",
+		"POST /_search/scroll",
+		"POST /_search/scroll",
+		"DELETE /_search/scroll",
+	}, paths)
+	assert.Empty(t, handlerErrs)
+}
@@ -0,0 +1,321 @@
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/levenlabs/go-llog"
+	"github.com/levenlabs/thumper/config"
+)
+
+// When a node fails it is ejected from the pool for deadTimeoutBase, doubling
+// for every consecutive failure up to deadTimeoutMax
+const (
+	deadTimeoutBase = 15 * time.Second
+	deadTimeoutMax  = 10 * time.Minute
+)
+
+// node describes a single elasticsearch node which requests can be sent to
+type node struct {
+	addr      string
+	failures  uint
+	deadUntil time.Time
+}
+
+func (n *node) alive(now time.Time) bool {
+	return !now.Before(n.deadUntil)
+}
+
+// nodePool keeps track of all known elasticsearch nodes, handing them out in
+// round-robin order and skipping over any which have recently failed
+type nodePool struct {
+	sync.Mutex
+	nodes   []*node
+	next    int
+	retries int
+}
+
+func newNodePool(addrs []string, retries int) *nodePool {
+	p := &nodePool{retries: retries}
+	p.setAddrs(addrs)
+	return p
+}
+
+var pool = newNodePool(config.ElasticSearchAddrs, config.ElasticSearchRetries)
+
+func init() {
+	if config.ElasticSearchSniff {
+		go pool.sniffSpin(config.ElasticSearchSniffInterval)
+	}
+}
+
+// setAddrs replaces the set of nodes in the pool, retaining the health state of
+// any addresses which were already known
+func (p *nodePool) setAddrs(addrs []string) {
+	p.Lock()
+	defer p.Unlock()
+
+	old := map[string]*node{}
+	for _, n := range p.nodes {
+		old[n.addr] = n
+	}
+
+	nodes := make([]*node, 0, len(addrs))
+	for _, addr := range addrs {
+		if n, ok := old[addr]; ok {
+			nodes = append(nodes, n)
+		} else {
+			nodes = append(nodes, &node{addr: addr})
+		}
+	}
+	p.nodes = nodes
+	if p.next >= len(p.nodes) {
+		p.next = 0
+	}
+}
+
+// addrs returns the addresses of all nodes in the pool, dead or alive
+func (p *nodePool) addrs() []string {
+	p.Lock()
+	defer p.Unlock()
+	addrs := make([]string, len(p.nodes))
+	for i := range p.nodes {
+		addrs[i] = p.nodes[i].addr
+	}
+	return addrs
+}
+
+// get returns the next live node's address in round-robin order, skipping any
+// addresses in exclude. If there are no live nodes left then the one which will
+// be resurrected soonest is returned. An empty string is returned only if every
+// node is excluded
+func (p *nodePool) get(exclude map[string]bool) string {
+	p.Lock()
+	defer p.Unlock()
+
+	now := time.Now()
+	var fallback *node
+	for i := 0; i < len(p.nodes); i++ {
+		n := p.nodes[(p.next+i)%len(p.nodes)]
+		if exclude[n.addr] {
+			continue
+		}
+		if n.alive(now) {
+			p.next = (p.next + i + 1) % len(p.nodes)
+			return n.addr
+		}
+		if fallback == nil || n.deadUntil.Before(fallback.deadUntil) {
+			fallback = n
+		}
+	}
+	if fallback == nil {
+		return ""
+	}
+	return fallback.addr
+}
+
+func (p *nodePool) find(addr string) *node {
+	for _, n := range p.nodes {
+		if n.addr == addr {
+			return n
+		}
+	}
+	return nil
+}
+
+// markDead ejects the node from the pool for a period of time based on how many
+// times in a row it has failed
+func (p *nodePool) markDead(addr string) {
+	p.Lock()
+	defer p.Unlock()
+	n := p.find(addr)
+	if n == nil {
+		return
+	}
+
+	timeout := deadTimeoutBase << n.failures
+	if timeout > deadTimeoutMax || timeout <= 0 {
+		timeout = deadTimeoutMax
+	} else {
+		n.failures++
+	}
+	n.deadUntil = time.Now().Add(timeout)
+	llog.Warn("marking elasticsearch node dead", llog.KV{"addr": addr, "timeout": timeout})
+}
+
+// markAlive resets the failure state of the node
+func (p *nodePool) markAlive(addr string) {
+	p.Lock()
+	defer p.Unlock()
+	if n := p.find(addr); n != nil {
+		n.failures = 0
+		n.deadUntil = time.Time{}
+	}
+}
+
+// request performs an http request against a node in the pool, returning the
+// status code and body of the response. If the node can't be reached, or
+// responds in a way indicating it is unhealthy, it is marked dead. If the
+// request is idempotent it will then be retried against a different node
+func (p *nodePool) request(method, path string, body []byte, idempotent bool) (int, []byte, error) {
+	return p.requestAuth(method, path, body, idempotent, false)
+}
+
+// requestAuth is like request, except that if basicAuth is set basic auth is
+// used even if an api key is configured
+func (p *nodePool) requestAuth(method, path string, body []byte, idempotent, basicAuth bool) (int, []byte, error) {
+	tries := 1
+	if idempotent {
+		tries += p.retries
+	}
+
+	tried := map[string]bool{}
+	var err error
+	for i := 0; i < tries; i++ {
+		addr := p.get(tried)
+		if addr == "" {
+			break
+		}
+		tried[addr] = true
+
+		var code int
+		var respBody []byte
+		code, respBody, err = doRequest(method, addr, path, body, basicAuth)
+		if err == nil && !unhealthyStatus(code) {
+			p.markAlive(addr)
+			return code, respBody, nil
+		} else if err == nil {
+			err = fmt.Errorf("unhealthy response from %s: %d", addr, code)
+		}
+
+		llog.Warn("elasticsearch request failed", llog.KV{
+			"addr":      addr,
+			"path":      path,
+			"err":       err,
+			"willRetry": i+1 < tries,
+		})
+		p.markDead(addr)
+	}
+
+	if err == nil {
+		err = errors.New("no elasticsearch nodes available")
+	}
+	return 0, nil, err
+}
+
+func unhealthyStatus(code int) bool {
+	return code == http.StatusBadGateway ||
+		code == http.StatusServiceUnavailable ||
+		code == http.StatusGatewayTimeout
+}
+
+// nodeURL returns the url for the given path on the node. Addresses without a
+// scheme are assumed to be plain http
+func nodeURL(addr, path string) string {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return strings.TrimSuffix(addr, "/") + path
+}
+
+// setAuth sets whatever authentication was given in the runtime configuration
+// on the request. opensearch doesn't support api keys, so basic auth is always
+// used for it, as well as when basicAuth is set or the api key was rejected
+// during version detection
+func setAuth(r *http.Request, basicAuth bool) {
+	if !basicAuth && useAPIKey() {
+		r.Header.Set("Authorization", "ApiKey "+config.ElasticSearchAPIKey)
+	} else if config.ElasticSearchUser != "" {
+		r.SetBasicAuth(config.ElasticSearchUser, config.ElasticSearchPassword)
+	}
+}
+
+func doRequest(method, addr, path string, body []byte, basicAuth bool) (int, []byte, error) {
+	req, err := http.NewRequest(method, nodeURL(addr, path), bytes.NewBuffer(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	setAuth(req, basicAuth)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, respBody, nil
+}
+
+type nodesInfo struct {
+	Nodes map[string]struct {
+		HTTP struct {
+			PublishAddress string `json:"publish_address"`
+		} `json:"http"`
+	} `json:"nodes"`
+}
+
+// sniff queries the nodes api for all http-enabled nodes in the cluster, and
+// replaces the pool's set of nodes with them
+func (p *nodePool) sniff() error {
+	code, body, err := p.request("GET", "/_nodes/http", nil, true)
+	if err != nil {
+		return err
+	} else if code != 200 {
+		return fmt.Errorf("non-200 response from nodes api: %d", code)
+	}
+
+	var info nodesInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return err
+	}
+
+	// sniffed addresses don't include a scheme, so assume the one the
+	// configured nodes are using
+	var scheme string
+	if seed := p.addrs(); len(seed) > 0 && strings.HasPrefix(seed[0], "https://") {
+		scheme = "https://"
+	}
+
+	addrs := make([]string, 0, len(info.Nodes))
+	for _, n := range info.Nodes {
+		// publish_address may be of the form "hostname/ip:port"
+		addr := n.HTTP.PublishAddress
+		if i := strings.LastIndex(addr, "/"); i >= 0 {
+			addr = addr[i+1:]
+		}
+		if addr != "" {
+			addrs = append(addrs, scheme+addr)
+		}
+	}
+	if len(addrs) == 0 {
+		return errors.New("nodes api returned no http nodes")
+	}
+
+	p.setAddrs(addrs)
+	return nil
+}
+
+func (p *nodePool) sniffSpin(interval time.Duration) {
+	for {
+		if err := p.sniff(); err != nil {
+			llog.Error("failed to sniff elasticsearch nodes", llog.KV{"err": err})
+		} else {
+			llog.Debug("sniffed elasticsearch nodes", llog.KV{"addrs": p.addrs()})
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,87 @@
+package search
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testServer(code int, body string) (*httptest.Server, string) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+		fmt.Fprint(w, body)
+	}))
+	return s, strings.TrimPrefix(s.URL, "http://")
+}
+
+func TestNodePoolRoundRobin(t *T) {
+	p := newNodePool([]string{"a", "b", "c"}, 0)
+	assert.Equal(t, "a", p.get(nil))
+	assert.Equal(t, "b", p.get(nil))
+	assert.Equal(t, "c", p.get(nil))
+	assert.Equal(t, "a", p.get(nil))
+
+	p.markDead("b")
+	assert.Equal(t, "c", p.get(nil))
+	assert.Equal(t, "a", p.get(nil))
+	assert.Equal(t, "c", p.get(nil))
+	assert.Equal(t, "c", p.get(map[string]bool{"a": true}))
+
+	// when everything is dead the soonest to be resurrected is used
+	p.markDead("a")
+	p.markDead("c")
+	p.markDead("c")
+	assert.Equal(t, "b", p.get(nil))
+	assert.Equal(t, "", p.get(map[string]bool{"a": true, "b": true, "c": true}))
+
+	p.markAlive("c")
+	assert.Equal(t, "c", p.get(nil))
+}
+
+func TestNodePoolRequest(t *T) {
+	good, goodAddr := testServer(200, "ok")
+	defer good.Close()
+	bad, badAddr := testServer(503, "")
+	defer bad.Close()
+
+	p := newNodePool([]string{badAddr, goodAddr}, 1)
+	code, body, err := p.request("GET", "/", nil, true)
+	require.Nil(t, err)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "ok", string(body))
+
+	// bad node should now be ejected, so it's skipped even when the request
+	// isn't retried
+	code, _, err = p.request("POST", "/", nil, false)
+	require.Nil(t, err)
+	assert.Equal(t, 200, code)
+
+	p = newNodePool([]string{badAddr, goodAddr}, 1)
+	_, _, err = p.request("POST", "/", nil, false)
+	assert.NotNil(t, err)
+}
+
+func TestNodePoolSniff(t *T) {
+	s, addr := testServer(200, `{"nodes":{
+		"a":{"http":{"publish_address":"10.0.0.1:9200"}},
+		"b":{"http":{"publish_address":"es-2/10.0.0.2:9200"}}
+	}}`)
+	defer s.Close()
+
+	p := newNodePool([]string{addr}, 0)
+	require.Nil(t, p.sniff())
+	addrs := p.addrs()
+	assert.Len(t, addrs, 2)
+	assert.Contains(t, addrs, "10.0.0.1:9200")
+	assert.Contains(t, addrs, "10.0.0.2:9200")
+}
+
+func TestNodeURL(t *T) {
+	assert.Equal(t, "http://127.0.0.1:9200/_search", nodeURL("127.0.0.1:9200", "/_search"))
+	assert.Equal(t, "https://es.local:9200/_search", nodeURL("https://es.local:9200/", "/_search"))
+}
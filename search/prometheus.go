@@ -0,0 +1,210 @@
+package search
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/levenlabs/go-llog"
+)
+
+// Series is a single time series returned by a prometheus query. Instant
+// queries return series with a single sample each
+type Series struct {
+	Labels  map[string]string
+	Samples []Sample
+}
+
+// Sample is a single value of a Series
+type Sample struct {
+	TS    float64 // Unix timestamp of the sample, may be fractional
+	Value float64
+}
+
+// UnmarshalJSON decodes a sample in the [<unix time>, "<value>"] format used
+// by the prometheus api
+func (s *Sample) UnmarshalJSON(b []byte) error {
+	var raw [2]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	ts, ok := raw[0].(float64)
+	if !ok {
+		return fmt.Errorf("invalid sample timestamp: %v", raw[0])
+	}
+	vs, ok := raw[1].(string)
+	if !ok {
+		return fmt.Errorf("invalid sample value: %v", raw[1])
+	}
+	v, err := strconv.ParseFloat(vs, 64)
+	if err != nil {
+		return err
+	}
+	s.TS, s.Value = ts, v
+	return nil
+}
+
+type promResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// series converts the response's result into Series, regardless of its type
+func (pr promResponse) series() ([]Series, error) {
+	switch pr.Data.ResultType {
+	case "vector", "matrix":
+		var rr []struct {
+			Metric map[string]string `json:"metric"`
+			Value  *Sample           `json:"value"`
+			Values []Sample          `json:"values"`
+		}
+		if err := json.Unmarshal(pr.Data.Result, &rr); err != nil {
+			return nil, err
+		}
+		series := make([]Series, len(rr))
+		for i, r := range rr {
+			series[i] = Series{Labels: r.Metric, Samples: r.Values}
+			if r.Value != nil {
+				series[i].Samples = []Sample{*r.Value}
+			}
+			if series[i].Labels == nil {
+				series[i].Labels = map[string]string{}
+			}
+		}
+		return series, nil
+	case "scalar":
+		var s Sample
+		if err := json.Unmarshal(pr.Data.Result, &s); err != nil {
+			return nil, err
+		}
+		return []Series{{Labels: map[string]string{}, Samples: []Sample{s}}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported prometheus result type: %q", pr.Data.ResultType)
+	}
+}
+
+// Prometheus is a datasource which performs the Query's PromQL against a
+// prometheus compatible http api. If Range is set a range query is performed
+// over that amount of time leading up to the Query's Time, otherwise an
+// instant query is performed at the Query's Time. The resulting series are put
+// in the Series field of the Result, and HitCount is set to the number of
+// series
+type Prometheus struct {
+	URL     string            `mapstructure:"url"` // e.g. http://127.0.0.1:9090
+	Range   string            `mapstructure:"range"`
+	Step    string            `mapstructure:"step"` // Defaults to 1m
+	Headers map[string]string `mapstructure:"headers"`
+	Timeout string            `mapstructure:"timeout"` // Defaults to 30s
+}
+
+// Search performs the PromQL query
+func (p *Prometheus) Search(q Query) (Result, error) {
+	if p.URL == "" {
+		return Result{}, errors.New("no url given for prometheus datasource")
+	} else if q.PromQL == "" {
+		return Result{}, errors.New("no promql query given for prometheus datasource")
+	}
+
+	timeout := defaultHTTPTimeout
+	if p.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(p.Timeout); err != nil {
+			return Result{}, fmt.Errorf("parsing prometheus datasource timeout: %s", err)
+		}
+	}
+
+	t := q.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	form := url.Values{"query": {q.PromQL}}
+	path := "/api/v1/query"
+	if p.Range == "" {
+		form.Set("time", promTime(t))
+	} else {
+		rng, err := time.ParseDuration(p.Range)
+		if err != nil {
+			return Result{}, fmt.Errorf("parsing prometheus datasource range: %s", err)
+		}
+		step := p.Step
+		if step == "" {
+			step = "1m"
+		}
+		if _, err := time.ParseDuration(step); err != nil {
+			return Result{}, fmt.Errorf("parsing prometheus datasource step: %s", err)
+		}
+		path = "/api/v1/query_range"
+		form.Set("start", promTime(t.Add(-rng)))
+		form.Set("end", promTime(t))
+		form.Set("step", step)
+	}
+
+	u := strings.TrimSuffix(p.URL, "/") + path
+	key := cacheKey("prometheus", u, fmt.Sprint(p.Headers), form.Encode())
+	return cached(key, func() (Result, error) {
+		return p.do(u, form, timeout)
+	})
+}
+
+func promTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', 3, 64)
+}
+
+func (p *Prometheus) do(u string, form url.Values, timeout time.Duration) (Result, error) {
+	r, err := http.NewRequest("POST", u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Result{}, err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for k, v := range p.Headers {
+		r.Header.Set(k, v)
+	}
+
+	start := time.Now()
+	resp, err := (&http.Client{Timeout: timeout}).Do(r)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Result{}, err
+	}
+	llog.Debug("prometheus results", llog.KV{"url": u, "status": resp.StatusCode, "body": string(body)})
+
+	// the api returns a json body describing the error for most non-2xx
+	// responses, so that's tried before falling back to the status code
+	var pr promResponse
+	if err := json.Unmarshal(body, &pr); err != nil {
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return Result{}, fmt.Errorf("non 2xx response code returned: %d", resp.StatusCode)
+		}
+		return Result{}, fmt.Errorf("decoding prometheus response: %s", err)
+	} else if pr.Status != "success" {
+		return Result{}, fmt.Errorf("prometheus query failed (%s): %s", pr.ErrorType, pr.Error)
+	}
+
+	series, err := pr.series()
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		TookMS: uint64(time.Since(start) / time.Millisecond),
+		HitInfo: HitInfo{
+			HitCount:         uint64(len(series)),
+			HitCountRelation: "eq",
+		},
+		Series: series,
+	}, nil
+}
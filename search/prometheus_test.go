@@ -0,0 +1,79 @@
+package search
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheus(t *T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, r.ParseForm())
+		switch r.Form.Get("query") {
+		case "up == 0":
+			assert.Equal(t, "/api/v1/query", r.URL.Path)
+			assert.Equal(t, "1514808000.000", r.Form.Get("time"))
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[
+				{"metric":{"__name__":"up","instance":"a:9100"},"value":[1514808000,"0"]},
+				{"metric":{"__name__":"up","instance":"b:9100"},"value":[1514808000,"0"]}
+			]}}`)
+		case "rate(errors[5m])":
+			assert.Equal(t, "/api/v1/query_range", r.URL.Path)
+			assert.Equal(t, "1514804400.000", r.Form.Get("start"))
+			assert.Equal(t, "1514808000.000", r.Form.Get("end"))
+			assert.Equal(t, "30m", r.Form.Get("step"))
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[
+				{"metric":{"job":"api"},"values":[[1514806200,"1.5"],[1514808000,"NaN"]]}
+			]}}`)
+		case "scalar(1)":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"scalar","result":[1514808000.5,"1"]}}`)
+		default:
+			w.WriteHeader(400)
+			fmt.Fprint(w, `{"status":"error","errorType":"bad_data","error":"parse error"}`)
+		}
+	}))
+	defer s.Close()
+
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	p := &Prometheus{URL: s.URL + "/"}
+	res, err := p.Search(Query{PromQL: "up == 0", Time: now})
+	require.Nil(t, err)
+	assert.Equal(t, uint64(2), res.HitCount)
+	assert.Equal(t, []Series{
+		{
+			Labels:  map[string]string{"__name__": "up", "instance": "a:9100"},
+			Samples: []Sample{{TS: 1514808000, Value: 0}},
+		},
+		{
+			Labels:  map[string]string{"__name__": "up", "instance": "b:9100"},
+			Samples: []Sample{{TS: 1514808000, Value: 0}},
+		},
+	}, res.Series)
+
+	p = &Prometheus{URL: s.URL, Range: "1h", Step: "30m"}
+	res, err = p.Search(Query{PromQL: "rate(errors[5m])", Time: now})
+	require.Nil(t, err)
+	require.Len(t, res.Series, 1)
+	assert.Equal(t, map[string]string{"job": "api"}, res.Series[0].Labels)
+	require.Len(t, res.Series[0].Samples, 2)
+	assert.Equal(t, Sample{TS: 1514806200, Value: 1.5}, res.Series[0].Samples[0])
+	assert.True(t, math.IsNaN(res.Series[0].Samples[1].Value))
+
+	p = &Prometheus{URL: s.URL}
+	res, err = p.Search(Query{PromQL: "scalar(1)", Time: now})
+	require.Nil(t, err)
+	assert.Equal(t, []Series{{
+		Labels:  map[string]string{},
+		Samples: []Sample{{TS: 1514808000.5, Value: 1}},
+	}}, res.Series)
+
+	_, err = p.Search(Query{PromQL: "wat(", Time: now})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "parse error")
+}
@@ -1,33 +1,127 @@
-// Package search deals with all search queries going to elasticsearch, and
-// returning their result
+// Package search deals with all search queries going to elasticsearch, or any of
+// the other datasources, and returning their result
 package search
 
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"sort"
 
 	"github.com/levenlabs/go-llog"
-	"github.com/levenlabs/thumper/config"
 )
 
-// Hit describes one of the documents matched by a search
+// Hit describes one of the documents matched by a search. Fields beyond the
+// first five are only filled in if the search asked for them
 type Hit struct {
 	Index  string                 `json:"_index"`  // The index the hit came from
 	Type   string                 `json:"_type"`   // The type the document is
 	ID     string                 `json:"_id"`     // The unique id of the document
 	Score  float64                `json:"_score"`  // The document's score relative to the search
 	Source map[string]interface{} `json:"_source"` // The actual document
+
+	Routing     string `json:"_routing"`      // The document's custom routing value
+	Version     int64  `json:"_version"`      // If the search set "version": true
+	SeqNo       int64  `json:"_seq_no"`       // If the search set "seq_no_primary_term": true
+	PrimaryTerm int64  `json:"_primary_term"` // If the search set "seq_no_primary_term": true
+
+	Sort           []interface{}          `json:"sort"`            // The hit's sort values, if the search was sorted
+	Highlight      map[string][]string    `json:"highlight"`       // Highlighted fragments, keyed by field
+	Fields         map[string]interface{} `json:"fields"`          // docvalue, stored and script fields, each value is an array
+	MatchedQueries []string               `json:"matched_queries"` // The names of the named queries the hit matched
+	InnerHits      map[string]InnerHits   `json:"inner_hits"`      // Keyed by the inner hits' name
+
+	// If the search set "include_named_queries_score": true, the score of
+	// each of MatchedQueries
+	MatchedQueryScores map[string]float64 `json:"-"`
+
+	// For the hits of InnerHits of nested documents, the nested document's
+	// position within the top-level document
+	Nested NestedIdentity `json:"_nested"`
+}
+
+// UnmarshalJSON decodes a hit. matched_queries is a list of names, unless the
+// search set include_named_queries_score, in which case it's an object of
+// names to scores
+func (h *Hit) UnmarshalJSON(b []byte) error {
+	type hit Hit
+	raw := struct {
+		*hit
+		MatchedQueries json.RawMessage `json:"matched_queries"`
+	}{hit: (*hit)(h)}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	h.MatchedQueries, h.MatchedQueryScores = nil, nil
+	switch {
+	case len(raw.MatchedQueries) == 0:
+		return nil
+	case raw.MatchedQueries[0] != '{':
+		return json.Unmarshal(raw.MatchedQueries, &h.MatchedQueries)
+	}
+	if err := json.Unmarshal(raw.MatchedQueries, &h.MatchedQueryScores); err != nil {
+		return err
+	}
+	for name := range h.MatchedQueryScores {
+		h.MatchedQueries = append(h.MatchedQueries, name)
+	}
+	sort.Strings(h.MatchedQueries)
+	return nil
+}
+
+// NestedIdentity describes where a nested document returned as an inner hit is
+// within its top-level document
+type NestedIdentity struct {
+	Field  string `json:"field"`
+	Offset int    `json:"offset"`
+}
+
+// InnerHits describes the documents matched by one of a Hit's inner_hits
+type InnerHits struct {
+	HitInfo `luautil:",inline"`
+}
+
+// UnmarshalJSON decodes one of the inner_hits of a hit, which is in the same
+// format as a search response's hits
+func (ih *InnerHits) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Hits struct {
+			HitInfo
+			Total hitTotal `json:"total"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	ih.HitInfo = raw.Hits.HitInfo
+	ih.HitCount = raw.Hits.Total.Value
+	ih.HitCountRelation = raw.Hits.Total.Relation
+	return nil
 }
 
 // HitInfo describes information in the Result related to the actual hits
 type HitInfo struct {
-	HitCount    uint64  `json:"total"`     // The total number of documents matched
-	HitMaxScore float64 `json:"max_score"` // The maximum score of all the documents matched
-	Hits        []Hit   `json:"hits"`      // The actual documents matched
+	HitCount         uint64  `json:"-"`         // The total number of documents matched
+	HitCountRelation string  `json:"-"`         // "eq" if HitCount is exact, "gte" if it's a lower bound
+	HitMaxScore      float64 `json:"max_score"` // The maximum score of all the documents matched
+	Hits             []Hit   `json:"hits"`      // The actual documents matched
+}
+
+// hitTotal decodes the total field of a search's hits, which is a plain number
+// prior to elasticsearch 7 and an object from then on
+type hitTotal struct {
+	Value    uint64 `json:"value"`
+	Relation string `json:"relation"`
+}
+
+func (ht *hitTotal) UnmarshalJSON(b []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		type total hitTotal // prevents recursion
+		return json.Unmarshal(b, (*total)(ht))
+	}
+	ht.Relation = "eq"
+	return json.Unmarshal(b, &ht.Value)
 }
 
 // Result describes the returned data from a search search
@@ -35,11 +129,46 @@ type Result struct {
 	TookMS       uint64                          `json:"took"`      // Time search took to complete, in milliseconds
 	TimedOut     bool                            `json:"timed_out"` // Whether or not the search timed out
 	HitInfo      `json:"hits" luautil:",inline"` // Information related to the actual hits
+	Shards       ShardInfo                       `json:"_shards"`      // How many shards were searched, and any failures
 	Aggregations map[string]interface{}          `json:"aggregations"` // Information related to aggregations in the query
+
+	// A normalized form of Aggregations
+	Aggs map[string]Aggregation `json:"-"`
+
+	// Tabular results, filled in by sql queries
+	Columns []Column `json:"-"`
+	Rows    []Row    `json:"-"`
+
+	// The decoded response of datasources which return arbitrary json
+	Data interface{} `json:"-"`
+
+	// Time series, filled in by prometheus queries
+	Series []Series `json:"-"`
+
+	// If the search failed and the alert is configured to process anyway, the
+	// error it failed with
+	Error Error `json:"-"`
 }
 
-type elasticError struct {
-	Error string `json:"reason"`
+// UnmarshalJSON decodes a search response body into the Result
+func (r *Result) UnmarshalJSON(b []byte) error {
+	type result Result // prevents recursion
+	if err := json.Unmarshal(b, (*result)(r)); err != nil {
+		return err
+	}
+
+	var total struct {
+		Hits struct {
+			Total hitTotal `json:"total"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(b, &total); err != nil {
+		return err
+	}
+	r.HitCount = total.Hits.Total.Value
+	r.HitCountRelation = total.Hits.Total.Relation
+	r.Aggs = ParseAggregations(r.Aggregations)
+	return nil
 }
 
 // Dict represents a key-value map which may be unmarshalled from a yaml
@@ -96,43 +225,55 @@ func mapToDict(m map[interface{}]interface{}) (Dict, error) {
 	return d, nil
 }
 
+func searchPath(index, typ string) string {
+	if typ != "" && !Version().typeless() {
+		return fmt.Sprintf("/%s/%s/_search", index, typ)
+	}
+	return fmt.Sprintf("/%s/_search", index)
+}
+
 // Search performs a search against the given elasticsearch index for
-// documents of the given type. The search must json marshal into a valid
-// elasticsearch request body query
+// documents of the given type. The type may be empty, in which case a typeless
+// search is performed (it is also ignored when the cluster doesn't support
+// types). The search must json marshal into a valid elasticsearch request body
+// query
 // (see https://www.elastic.co/guide/en/elasticsearch/reference/current/search-request-body.html)
+//
+// If batching is enabled the search may be combined with others made around
+// the same time into a single multi search request. If caching is enabled an
+// identical search made recently may be served from the cache
 func Search(index, typ string, search interface{}) (Result, error) {
-	u := fmt.Sprintf("http://%s/%s/%s/_search", config.ElasticSearchAddr, index, typ)
 	bodyReq, err := json.Marshal(search)
 	if err != nil {
 		return Result{}, err
 	}
 
-	req, err := http.NewRequest("GET", u, bytes.NewBuffer(bodyReq))
-	if err != nil {
-		return Result{}, err
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return Result{}, err
-	}
-	defer resp.Body.Close()
+	key := cacheKey(cacheDatasource, "search", index, typ, string(bodyReq))
+	return cached(key, func() (Result, error) {
+		if batch != nil {
+			return batch.search(index, typ, bodyReq)
+		}
+		return doSearch(index, typ, bodyReq)
+	})
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
+// doSearch performs a single search request against the cluster
+func doSearch(index, typ string, bodyReq []byte) (Result, error) {
+	code, body, err := pool.request("GET", searchPath(index, typ), bodyReq, true)
 	if err != nil {
 		return Result{}, err
 	}
+	return decodeResult(code, body)
+}
 
+// decodeResult decodes the response to a single search, returning an error if
+// the response indicates the search failed
+func decodeResult(code int, body []byte) (Result, error) {
 	kv := llog.KV{"body": string(body)}
 	llog.Debug("search results", kv)
 
-	if resp.StatusCode != 200 {
-		var e elasticError
-		if err := json.Unmarshal(body, &e); err != nil {
-			llog.Error("could not unmarshal error body", kv, llog.ErrKV(err))
-			return Result{}, err
-		}
-		return Result{}, errors.New(e.Error)
+	if code != 200 {
+		return Result{}, parseError(code, body)
 	}
 
 	var result Result
@@ -140,7 +281,7 @@ func Search(index, typ string, search interface{}) (Result, error) {
 		llog.Error("could not unmarshal search result", kv, llog.ErrKV(err))
 		return result, err
 	} else if result.TimedOut {
-		return result, errors.New("search timed out in elasticsearch")
+		return result, &Error{Status: code, Type: "timeout", Reason: "search timed out in elasticsearch"}
 	}
 
 	return result, nil
@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	. "testing"
 
 	"github.com/stretchr/testify/assert"
@@ -28,3 +29,117 @@ biz:
 	assert.Equal(t, Dict{"a": 1, "b": 2}, d["biz"].([]interface{})[1])
 	assert.Equal(t, Dict{"c": 3, "d": 4}, d["biz"].([]interface{})[2])
 }
+
+func TestResultHitTotal(t *T) {
+	var r Result
+	require.Nil(t, json.Unmarshal([]byte(`{"took":5,"hits":{"total":12,"hits":[]}}`), &r))
+	assert.Equal(t, uint64(5), r.TookMS)
+	assert.Equal(t, uint64(12), r.HitCount)
+	assert.Equal(t, "eq", r.HitCountRelation)
+
+	r = Result{}
+	require.Nil(t, json.Unmarshal([]byte(`{"took":5,"hits":{"total":{"value":10000,"relation":"gte"},"hits":[{"_id":"a"}]}}`), &r))
+	assert.Equal(t, uint64(10000), r.HitCount)
+	assert.Equal(t, "gte", r.HitCountRelation)
+	assert.Equal(t, []Hit{{ID: "a"}}, r.Hits)
+}
+
+func TestResultHitMetadata(t *T) {
+	body := `{
+		"hits": {
+			"total": {"value": 1, "relation": "eq"},
+			"max_score": null,
+			"hits": [{
+				"_index": "posts",
+				"_id": "1",
+				"_score": null,
+				"_routing": "user1",
+				"_version": 3,
+				"_seq_no": 10,
+				"_primary_term": 1,
+				"_source": {"title": "foo bar"},
+				"sort": [1514808000000, "b"],
+				"highlight": {"title": ["<em>foo</em> bar"]},
+				"fields": {"views": [42]},
+				"matched_queries": ["titled"],
+				"inner_hits": {
+					"comments": {
+						"hits": {
+							"total": 2,
+							"max_score": 1.5,
+							"hits": [{
+								"_index": "posts",
+								"_id": "1",
+								"_nested": {"field": "comments", "offset": 1},
+								"_score": 1.5,
+								"_source": {"text": "foo"}
+							}]
+						}
+					}
+				}
+			}]
+		}
+	}`
+
+	var r Result
+	require.Nil(t, json.Unmarshal([]byte(body), &r))
+	require.Len(t, r.Hits, 1)
+	h := r.Hits[0]
+	assert.Equal(t, "user1", h.Routing)
+	assert.Equal(t, int64(3), h.Version)
+	assert.Equal(t, int64(10), h.SeqNo)
+	assert.Equal(t, int64(1), h.PrimaryTerm)
+	assert.Equal(t, []interface{}{float64(1514808000000), "b"}, h.Sort)
+	assert.Equal(t, map[string][]string{"title": {"<em>foo</em> bar"}}, h.Highlight)
+	assert.Equal(t, map[string]interface{}{"views": []interface{}{float64(42)}}, h.Fields)
+	assert.Equal(t, []string{"titled"}, h.MatchedQueries)
+	assert.Nil(t, h.MatchedQueryScores)
+
+	require.Contains(t, h.InnerHits, "comments")
+	ih := h.InnerHits["comments"]
+	assert.Equal(t, uint64(2), ih.HitCount)
+	assert.Equal(t, "eq", ih.HitCountRelation)
+	assert.Equal(t, 1.5, ih.HitMaxScore)
+	assert.Equal(t, []Hit{{
+		Index:  "posts",
+		ID:     "1",
+		Score:  1.5,
+		Source: map[string]interface{}{"text": "foo"},
+		Nested: NestedIdentity{Field: "comments", Offset: 1},
+	}}, ih.Hits)
+}
+
+func TestResultMatchedQueryScores(t *T) {
+	// recorded from an elasticsearch 8.11 cluster, with the search setting
+	// include_named_queries_score
+	body := `{
+		"took": 2,
+		"timed_out": false,
+		"_shards": {"total": 1, "successful": 1, "skipped": 0, "failed": 0},
+		"hits": {
+			"total": {"value": 1, "relation": "eq"},
+			"max_score": 1.2876821,
+			"hits": [{
+				"_index": "posts",
+				"_id": "1",
+				"_score": 1.2876821,
+				"_source": {"title": "foo bar"},
+				"matched_queries": {"titled": 1.2876821, "all": 1.0}
+			}]
+		}
+	}`
+
+	var r Result
+	require.Nil(t, json.Unmarshal([]byte(body), &r))
+	require.Len(t, r.Hits, 1)
+	h := r.Hits[0]
+	assert.Equal(t, "1", h.ID)
+	assert.Equal(t, map[string]interface{}{"title": "foo bar"}, h.Source)
+	assert.Equal(t, []string{"all", "titled"}, h.MatchedQueries)
+	assert.Equal(t, map[string]float64{"titled": 1.2876821, "all": 1.0}, h.MatchedQueryScores)
+}
+
+func TestSearchPath(t *T) {
+	assert.Equal(t, "/foo/bar/_search", searchPath("foo", "bar"))
+	assert.Equal(t, "/foo/_search", searchPath("foo", ""))
+}
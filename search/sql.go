@@ -0,0 +1,163 @@
+package search
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/levenlabs/go-llog"
+)
+
+// Column describes a single column of a tabular result
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Row is a single row of a tabular result, keyed by column name
+type Row map[string]interface{}
+
+// sqlResponse covers both elasticsearch's response format and opensearch's
+// default (jdbc) response format
+type sqlResponse struct {
+	Columns  []Column        `json:"columns"`
+	Rows     [][]interface{} `json:"rows"`
+	Schema   []Column        `json:"schema"`
+	DataRows [][]interface{} `json:"datarows"`
+	Cursor   string          `json:"cursor"`
+}
+
+func (sr sqlResponse) columns() []Column {
+	if sr.Schema != nil {
+		return sr.Schema
+	}
+	return sr.Columns
+}
+
+func (sr sqlResponse) rows() [][]interface{} {
+	if sr.DataRows != nil {
+		return sr.DataRows
+	}
+	return sr.Rows
+}
+
+// sqlPath returns the path of the sql endpoint, or of one of its
+// sub-endpoints (e.g. "/close")
+func sqlPath(sub string) string {
+	if Version().Flavor == FlavorOpenSearch {
+		return "/_plugins/_sql" + sub
+	} else if sub == "" {
+		return "/_sql?format=json"
+	}
+	return "/_sql" + sub
+}
+
+// sqlRequest performs a single request against one of the sql or ppl
+// endpoints. A non-200 response is decoded as a normal search error
+func sqlRequest(path string, body interface{}) (sqlResponse, error) {
+	bodyReq, err := json.Marshal(body)
+	if err != nil {
+		return sqlResponse{}, err
+	}
+
+	code, bodyResp, err := pool.request("POST", path, bodyReq, true)
+	if err != nil {
+		return sqlResponse{}, err
+	}
+	if code != 200 {
+		return sqlResponse{}, parseError(code, bodyResp)
+	}
+
+	var sr sqlResponse
+	err = json.Unmarshal(bodyResp, &sr)
+	return sr, err
+}
+
+// appendRows converts the array rows of a sql response into Rows, appending
+// them to the Result. Returns false if the Result has reached max rows
+func appendRows(res *Result, cols []Column, rows [][]interface{}, max int) bool {
+	for _, r := range rows {
+		if max > 0 && len(res.Rows) >= max {
+			return false
+		}
+		row := make(Row, len(cols))
+		for i := range cols {
+			if i < len(r) {
+				row[cols[i].Name] = r[i]
+			}
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	return max <= 0 || len(res.Rows) < max
+}
+
+// SQL performs the given sql query using the cluster's sql endpoint. The
+// Columns and Rows of the returned Result are filled in, and HitCount is set to
+// the number of rows returned. Only the first page of rows is returned unless
+// p.MaxHits is set, in which case the query's cursor is followed until that
+// many rows have been fetched
+func SQL(query string, p Pagination) (Result, error) {
+	key := cacheKey(cacheDatasource, "sql", query, strconv.Itoa(p.MaxHits), strconv.Itoa(p.PageSize))
+	return cached(key, func() (Result, error) {
+		return doSQL(query, p)
+	})
+}
+
+func doSQL(query string, p Pagination) (Result, error) {
+	body := map[string]interface{}{"query": query}
+	if p.MaxHits > 0 {
+		p = p.withDefaults()
+		body["fetch_size"] = p.PageSize
+	}
+
+	sr, err := sqlRequest(sqlPath(""), body)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// subsequent pages don't include the columns
+	res := Result{Columns: sr.columns()}
+	more := appendRows(&res, res.Columns, sr.rows(), p.MaxHits)
+	for more && p.MaxHits > 0 && sr.Cursor != "" {
+		if sr, err = sqlRequest(sqlPath(""), map[string]string{"cursor": sr.Cursor}); err != nil {
+			return res, err
+		}
+		more = appendRows(&res, res.Columns, sr.rows(), p.MaxHits)
+	}
+
+	if sr.Cursor != "" {
+		if _, err := sqlRequest(sqlPath("/close"), map[string]string{"cursor": sr.Cursor}); err != nil {
+			llog.Warn("failed to close sql cursor", llog.KV{"err": err})
+		}
+	}
+
+	res.HitCount = uint64(len(res.Rows))
+	res.HitCountRelation = "eq"
+	return res, nil
+}
+
+// PPL performs the given query using opensearch's piped processing language
+// endpoint. The Result is filled in the same way as for SQL. This is only
+// supported by opensearch
+func PPL(query string) (Result, error) {
+	if Version().Flavor != FlavorOpenSearch {
+		return Result{}, errors.New("ppl queries are only supported by opensearch")
+	}
+
+	return cached(cacheKey(cacheDatasource, "ppl", query), func() (Result, error) {
+		return doPPL(query)
+	})
+}
+
+func doPPL(query string) (Result, error) {
+	sr, err := sqlRequest("/_plugins/_ppl", map[string]string{"query": query})
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := Result{Columns: sr.columns()}
+	appendRows(&res, res.Columns, sr.rows(), 0)
+	res.HitCount = uint64(len(res.Rows))
+	res.HitCountRelation = "eq"
+	return res, nil
+}
@@ -0,0 +1,100 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQL(t *T) {
+	var paths []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+		var body map[string]interface{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+
+		switch {
+		case r.URL.Path == "/_sql/close":
+			fmt.Fprint(w, `{"succeeded":true}`)
+		case body["query"] != nil:
+			fmt.Fprint(w, `{
+				"columns": [{"name":"host","type":"keyword"},{"name":"errors","type":"long"}],
+				"rows": [["web1", 3], ["web2", 5]],
+				"cursor": "c1"
+			}`)
+		case body["cursor"] == "c1":
+			fmt.Fprint(w, `{"rows": [["web3", 1], ["web4", 0]], "cursor": "c2"}`)
+		default:
+			t.Errorf("unexpected request: %s %v", r.URL, body)
+			w.WriteHeader(400)
+		}
+	}))
+	defer s.Close()
+
+	oldPool, oldVersion := pool, version
+	defer func() { pool, version = oldPool, oldVersion }()
+	pool = newNodePool([]string{strings.TrimPrefix(s.URL, "http://")}, 0)
+	version = ClusterVersion{Flavor: FlavorElasticsearch, Major: 7, Minor: 10}
+
+	res, err := SQL("SELECT host, COUNT(*) AS errors FROM logs GROUP BY host", Pagination{MaxHits: 3, PageSize: 2})
+	require.Nil(t, err)
+	assert.Equal(t, []Column{{"host", "keyword"}, {"errors", "long"}}, res.Columns)
+	assert.Equal(t, []Row{
+		{"host": "web1", "errors": float64(3)},
+		{"host": "web2", "errors": float64(5)},
+		{"host": "web3", "errors": float64(1)},
+	}, res.Rows)
+	assert.Equal(t, uint64(3), res.HitCount)
+	assert.Equal(t, []string{"/_sql?format=json", "/_sql?format=json", "/_sql/close"}, paths)
+
+	// without pagination only the first page is fetched
+	paths = nil
+	res, err = SQL("SELECT host, COUNT(*) AS errors FROM logs GROUP BY host", Pagination{})
+	require.Nil(t, err)
+	assert.Len(t, res.Rows, 2)
+	assert.Equal(t, []string{"/_sql?format=json", "/_sql/close"}, paths)
+}
+
+func TestOpenSearchSQL(t *T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// recorded from an opensearch 2.11 cluster
+		switch r.URL.Path {
+		case "/_plugins/_sql", "/_plugins/_ppl":
+			fmt.Fprint(w, `{
+				"schema": [{"name": "host", "type": "keyword"}, {"name": "errors", "type": "long"}],
+				"datarows": [["web1", 3]],
+				"total": 1,
+				"size": 1,
+				"status": 200
+			}`)
+		default:
+			t.Errorf("unexpected request: %s", r.URL)
+			w.WriteHeader(400)
+		}
+	}))
+	defer s.Close()
+
+	oldPool, oldVersion := pool, version
+	defer func() { pool, version = oldPool, oldVersion }()
+	pool = newNodePool([]string{strings.TrimPrefix(s.URL, "http://")}, 0)
+	version = ClusterVersion{Flavor: FlavorOpenSearch, Major: 2, Minor: 11}
+
+	res, err := SQL("SELECT host, COUNT(*) AS errors FROM logs GROUP BY host", Pagination{})
+	require.Nil(t, err)
+	assert.Equal(t, []Row{{"host": "web1", "errors": float64(3)}}, res.Rows)
+
+	res, err = PPL("source=logs | stats count() as errors by host")
+	require.Nil(t, err)
+	assert.Equal(t, []Column{{"host", "keyword"}, {"errors", "long"}}, res.Columns)
+	assert.Equal(t, uint64(1), res.HitCount)
+
+	version.Flavor = FlavorElasticsearch
+	_, err = PPL("source=logs")
+	assert.NotNil(t, err)
+}
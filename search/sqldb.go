@@ -0,0 +1,160 @@
+package search
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	// drivers for the sql datasource
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+)
+
+const defaultSQLTimeout = 30 * time.Second
+
+// Connection pools for the sql datasource, keyed by sqlDBKey. Since
+// datasources are re-created on every run of an alert the pools are kept here
+// so they can be shared between runs, as well as between alerts
+var (
+	sqlDBsL sync.Mutex
+	sqlDBs  = map[string]*sql.DB{}
+)
+
+// SQLDatabase is a datasource which performs the Query's SQL against a
+// database using one of the registered database/sql drivers (postgres and
+// mysql are included). The Columns and Rows of the Result are filled in, and
+// HitCount is set to the number of rows returned
+type SQLDatabase struct {
+	Driver  string `mapstructure:"driver"`
+	DSN     string `mapstructure:"dsn"`
+	Timeout string `mapstructure:"timeout"` // Defaults to 30s
+
+	// Only take effect when the connection pool for the driver and dsn is
+	// first created. MaxOpenConns defaults to unlimited, and MaxIdleConns to
+	// the database/sql default
+	MaxOpenConns int `mapstructure:"max_open_conns"`
+	MaxIdleConns int `mapstructure:"max_idle_conns"`
+}
+
+// sqlDBKey returns the key of the connection pool for the driver and dsn. The
+// dsn generally contains the database's password, so it's hashed
+func sqlDBKey(driver, dsn string) string {
+	h := sha256.Sum256([]byte(driver + "\x00" + dsn))
+	return hex.EncodeToString(h[:])
+}
+
+// db returns the connection pool for the datasource, creating it if it
+// doesn't exist yet
+func (s *SQLDatabase) db() (*sql.DB, error) {
+	sqlDBsL.Lock()
+	defer sqlDBsL.Unlock()
+
+	key := sqlDBKey(s.Driver, s.DSN)
+	if db, ok := sqlDBs[key]; ok {
+		return db, nil
+	}
+
+	db, err := sql.Open(s.Driver, s.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if s.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(s.MaxOpenConns)
+	}
+	if s.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(s.MaxIdleConns)
+	}
+	sqlDBs[key] = db
+	return db, nil
+}
+
+// Search performs the Query's SQL against the database
+func (s *SQLDatabase) Search(q Query) (Result, error) {
+	if s.Driver == "" || s.DSN == "" {
+		return Result{}, errors.New("driver and dsn are required for sql datasource")
+	} else if q.SQL == "" {
+		return Result{}, errors.New("no sql query given for sql datasource")
+	}
+
+	timeout := defaultSQLTimeout
+	if s.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(s.Timeout); err != nil {
+			return Result{}, fmt.Errorf("parsing sql datasource timeout: %s", err)
+		}
+	}
+
+	// cacheKey hashes the dsn, so its password doesn't end up in the logs
+	key := cacheKey("sql", s.Driver, s.DSN, q.SQL)
+	return cached(key, func() (Result, error) {
+		return s.do(q.SQL, timeout)
+	})
+}
+
+func (s *SQLDatabase) do(query string, timeout time.Duration) (Result, error) {
+	db, err := s.db()
+	if err != nil {
+		return Result{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return Result{}, err
+	}
+	defer rows.Close()
+
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return Result{}, err
+	}
+	res := Result{Columns: make([]Column, len(colTypes))}
+	for i, ct := range colTypes {
+		res.Columns[i] = Column{Name: ct.Name(), Type: ct.DatabaseTypeName()}
+	}
+
+	vals := make([]interface{}, len(colTypes))
+	ptrs := make([]interface{}, len(colTypes))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return Result{}, err
+		}
+		row := make(Row, len(colTypes))
+		for i, col := range res.Columns {
+			row[col.Name] = sqlValue(vals[i])
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return Result{}, err
+	}
+
+	res.TookMS = uint64(time.Since(start) / time.Millisecond)
+	res.HitCount = uint64(len(res.Rows))
+	res.HitCountRelation = "eq"
+	return res, nil
+}
+
+// sqlValue converts values returned by database/sql drivers into ones which
+// can be used in the context
+func sqlValue(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case []byte:
+		return string(vv)
+	case time.Time:
+		return vv.Format(time.RFC3339Nano)
+	default:
+		return vv
+	}
+}
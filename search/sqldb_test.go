@@ -0,0 +1,59 @@
+package search
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	. "testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLDatabase(t *T) {
+	dir, err := ioutil.TempDir("", "thumper")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ds := &SQLDatabase{
+		Driver:  "sqlite3",
+		DSN:     filepath.Join(dir, "test.db"),
+		Timeout: "5s",
+	}
+	db, err := ds.db()
+	require.Nil(t, err)
+	_, err = db.Exec(`CREATE TABLE orders (id INTEGER, status TEXT, total REAL)`)
+	require.Nil(t, err)
+	_, err = db.Exec(`INSERT INTO orders VALUES (1, 'failed', 9.5), (2, 'ok', 20), (3, 'failed', 1.25)`)
+	require.Nil(t, err)
+
+	// the same pool should be returned for the same driver and dsn
+	db2, err := ds.db()
+	require.Nil(t, err)
+	assert.True(t, db == db2)
+
+	// the dsn may contain a password, so it mustn't be part of the pool's key
+	sqlDBsL.Lock()
+	for key := range sqlDBs {
+		assert.NotContains(t, key, ds.DSN)
+	}
+	sqlDBsL.Unlock()
+
+	res, err := ds.Search(Query{SQL: "SELECT id, total FROM orders WHERE status = 'failed' ORDER BY id"})
+	require.Nil(t, err)
+	assert.Equal(t, uint64(2), res.HitCount)
+	require.Len(t, res.Columns, 2)
+	assert.Equal(t, "id", res.Columns[0].Name)
+	assert.Equal(t, "total", res.Columns[1].Name)
+	assert.Equal(t, []Row{
+		{"id": int64(1), "total": 9.5},
+		{"id": int64(3), "total": 1.25},
+	}, res.Rows)
+
+	_, err = ds.Search(Query{SQL: "SELECT * FROM nope"})
+	assert.NotNil(t, err)
+
+	_, err = ds.Search(Query{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,186 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/levenlabs/go-llog"
+	"github.com/levenlabs/thumper/config"
+)
+
+// Flavor describes which search engine a cluster is running. OpenSearch forked
+// from elasticsearch 7.10, and while its apis are mostly compatible its version
+// numbers and some endpoints differ
+type Flavor string
+
+// All the known flavors
+const (
+	FlavorElasticsearch Flavor = "elasticsearch"
+	FlavorOpenSearch    Flavor = "opensearch"
+)
+
+// ParseFlavor parses a flavor as given in the runtime configuration. An empty
+// Flavor is returned for "auto", meaning the flavor should be detected
+func ParseFlavor(s string) (Flavor, error) {
+	switch f := Flavor(strings.ToLower(s)); f {
+	case "", "auto":
+		return "", nil
+	case FlavorElasticsearch, FlavorOpenSearch:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown flavor: %q", s)
+	}
+}
+
+// ClusterVersion describes the flavor and version of the search engine the
+// cluster is running
+type ClusterVersion struct {
+	Flavor Flavor
+	Number string // The full version string, e.g. "7.10.2"
+	Major  int
+	Minor  int
+}
+
+// typeless returns whether the cluster no longer accepts mapping types in
+// search urls
+func (v ClusterVersion) typeless() bool {
+	if v.Flavor == FlavorOpenSearch {
+		return v.Major >= 2
+	}
+	return v.Major >= 8
+}
+
+func (v ClusterVersion) String() string {
+	num := v.Number
+	if num == "" {
+		num = "unknown"
+	}
+	if v.Flavor == "" {
+		return num
+	}
+	return fmt.Sprintf("%s %s", v.Flavor, num)
+}
+
+var (
+	versionL sync.RWMutex
+	version  ClusterVersion
+
+	// set if the api key was rejected during version detection but basic
+	// auth wasn't, in which case basic auth is used from then on
+	apiKeyRejected bool
+)
+
+// Version returns the version of the cluster which was found by DetectVersion.
+// If DetectVersion hasn't been called, or failed, the zero value is returned
+// (with the Flavor filled in if it was given in the runtime configuration)
+func Version() ClusterVersion {
+	versionL.RLock()
+	defer versionL.RUnlock()
+	return version
+}
+
+// useAPIKey returns whether requests should be authenticated with the api key
+// rather than basic auth
+func useAPIKey() bool {
+	versionL.RLock()
+	defer versionL.RUnlock()
+	return config.ElasticSearchAPIKey != "" && !apiKeyRejected && version.Flavor != FlavorOpenSearch
+}
+
+func init() {
+	f, err := ParseFlavor(config.ElasticSearchFlavor)
+	if err != nil {
+		llog.Fatal("invalid --elasticsearch-flavor", llog.KV{"err": err})
+	}
+	version.Flavor = f
+}
+
+func parseVersion(number string) (ClusterVersion, error) {
+	v := ClusterVersion{Number: number}
+	parts := strings.SplitN(number, ".", 3)
+	if len(parts) < 2 {
+		return v, fmt.Errorf("malformed version number: %q", number)
+	}
+
+	var err error
+	if v.Major, err = strconv.Atoi(parts[0]); err != nil {
+		return v, fmt.Errorf("malformed version number: %q", number)
+	}
+	if v.Minor, err = strconv.Atoi(parts[1]); err != nil {
+		return v, fmt.Errorf("malformed version number: %q", number)
+	}
+	return v, nil
+}
+
+type rootInfo struct {
+	Version struct {
+		Number       string `json:"number"`
+		Distribution string `json:"distribution"`
+	} `json:"version"`
+}
+
+// parseRootInfo parses the response to a request for the cluster's root
+// endpoint. If the given flavor is empty it is detected from the response
+func parseRootInfo(body []byte, flavor Flavor) (ClusterVersion, error) {
+	var info rootInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return ClusterVersion{}, err
+	}
+
+	v, err := parseVersion(info.Version.Number)
+	if err != nil {
+		return ClusterVersion{}, err
+	}
+
+	// opensearch reports itself in the distribution field, elasticsearch
+	// leaves it out entirely
+	if v.Flavor = flavor; v.Flavor == "" {
+		v.Flavor = FlavorElasticsearch
+		if info.Version.Distribution == string(FlavorOpenSearch) {
+			v.Flavor = FlavorOpenSearch
+		}
+	}
+	return v, nil
+}
+
+// DetectVersion queries the cluster for the flavor and version of the search
+// engine it's running. The result is remembered and used to decide how
+// subsequent searches are performed. If a flavor was given in the runtime
+// configuration it is used instead of the detected one
+func DetectVersion() (ClusterVersion, error) {
+	code, body, err := pool.request("GET", "/", nil, true)
+
+	// until the flavor is known the api key is sent, which opensearch
+	// rejects. If there's a user configured too it's tried instead, and if
+	// that works it's used for all later requests, whatever the flavor
+	fallback := false
+	if err == nil && code == http.StatusUnauthorized &&
+		config.ElasticSearchAPIKey != "" && config.ElasticSearchUser != "" {
+		llog.Info("api key rejected, retrying version detection with basic auth", llog.KV{"user": config.ElasticSearchUser})
+		code, body, err = pool.requestAuth("GET", "/", nil, true, true)
+		fallback = true
+	}
+
+	if err != nil {
+		return ClusterVersion{}, err
+	} else if code != 200 {
+		return ClusterVersion{}, fmt.Errorf("non-200 response from elasticsearch: %d", code)
+	}
+
+	versionL.Lock()
+	defer versionL.Unlock()
+	v, err := parseRootInfo(body, version.Flavor)
+	if err != nil {
+		return ClusterVersion{}, err
+	}
+	version = v
+	if fallback && v.Flavor != FlavorOpenSearch {
+		apiKeyRejected = true
+		llog.Warn("api key rejected by elasticsearch, using basic auth instead", llog.KV{"user": config.ElasticSearchUser})
+	}
+	return v, nil
+}
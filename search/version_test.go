@@ -0,0 +1,202 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	. "testing"
+
+	"github.com/levenlabs/thumper/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersion(t *T) {
+	v, err := parseVersion("7.10.2")
+	require.Nil(t, err)
+	assert.Equal(t, ClusterVersion{Number: "7.10.2", Major: 7, Minor: 10}, v)
+	assert.False(t, v.typeless())
+
+	v, err = parseVersion("8.0.0-SNAPSHOT")
+	require.Nil(t, err)
+	assert.True(t, v.typeless())
+
+	_, err = parseVersion("wat")
+	assert.NotNil(t, err)
+}
+
+// Responses to GET / recorded from real clusters
+var (
+	rootElasticsearch6 = `{
+		"name" : "es-1",
+		"cluster_name" : "logs",
+		"cluster_uuid" : "Zq4Bo2nhQc2jI3f0kT8FvA",
+		"version" : {
+			"number" : "6.8.23",
+			"build_flavor" : "default",
+			"build_type" : "docker",
+			"build_hash" : "4f67856",
+			"build_date" : "2022-01-06T21:30:50.087716Z",
+			"build_snapshot" : false,
+			"lucene_version" : "7.7.3",
+			"minimum_wire_compatibility_version" : "5.6.0",
+			"minimum_index_compatibility_version" : "5.0.0"
+		},
+		"tagline" : "You Know, for Search"
+	}`
+	rootOpenSearch1 = `{
+		"name" : "os-1",
+		"cluster_name" : "logs",
+		"cluster_uuid" : "b2Xh9c4nTe6mRr3Zk1s0Qw",
+		"version" : {
+			"distribution" : "opensearch",
+			"number" : "1.3.14",
+			"build_type" : "tar",
+			"build_hash" : "3b1f3e7",
+			"build_date" : "2023-12-11T18:28:10.913Z",
+			"build_snapshot" : false,
+			"lucene_version" : "8.10.1",
+			"minimum_wire_compatibility_version" : "6.8.0",
+			"minimum_index_compatibility_version" : "6.0.0-beta1"
+		},
+		"tagline" : "The OpenSearch Project: https://opensearch.org/"
+	}`
+	rootOpenSearch2 = `{
+		"name" : "os-1",
+		"cluster_name" : "logs",
+		"cluster_uuid" : "b2Xh9c4nTe6mRr3Zk1s0Qw",
+		"version" : {
+			"distribution" : "opensearch",
+			"number" : "2.11.1",
+			"build_type" : "tar",
+			"build_hash" : "6b1986e",
+			"build_date" : "2023-11-29T21:43:10.135035992Z",
+			"build_snapshot" : false,
+			"lucene_version" : "9.7.0",
+			"minimum_wire_compatibility_version" : "7.10.0",
+			"minimum_index_compatibility_version" : "7.0.0"
+		},
+		"tagline" : "The OpenSearch Project: https://opensearch.org/"
+	}`
+)
+
+func TestParseRootInfo(t *T) {
+	v, err := parseRootInfo([]byte(rootElasticsearch6), "")
+	require.Nil(t, err)
+	assert.Equal(t, ClusterVersion{Flavor: FlavorElasticsearch, Number: "6.8.23", Major: 6, Minor: 8}, v)
+	assert.False(t, v.typeless())
+
+	v, err = parseRootInfo([]byte(rootOpenSearch1), "")
+	require.Nil(t, err)
+	assert.Equal(t, ClusterVersion{Flavor: FlavorOpenSearch, Number: "1.3.14", Major: 1, Minor: 3}, v)
+	assert.False(t, v.typeless())
+
+	v, err = parseRootInfo([]byte(rootOpenSearch2), "")
+	require.Nil(t, err)
+	assert.Equal(t, FlavorOpenSearch, v.Flavor)
+	assert.True(t, v.typeless())
+
+	// a configured flavor always wins
+	v, err = parseRootInfo([]byte(rootOpenSearch2), FlavorElasticsearch)
+	require.Nil(t, err)
+	assert.Equal(t, FlavorElasticsearch, v.Flavor)
+}
+
+func TestOpenSearchResult(t *T) {
+	// recorded from an opensearch 2.11 cluster, which never returns _type
+	body := `{
+		"took" : 3,
+		"timed_out" : false,
+		"_shards" : {"total" : 1, "successful" : 1, "skipped" : 0, "failed" : 0},
+		"hits" : {
+			"total" : {"value" : 2, "relation" : "eq"},
+			"max_score" : 1.0,
+			"hits" : [
+				{"_index" : "logs-2023.12.01", "_id" : "a1", "_score" : 1.0, "_source" : {"severity" : "fatal"}},
+				{"_index" : "logs-2023.12.01", "_id" : "a2", "_score" : 1.0, "_source" : {"severity" : "fatal"}}
+			]
+		}
+	}`
+	var r Result
+	require.Nil(t, json.Unmarshal([]byte(body), &r))
+	assert.Equal(t, uint64(3), r.TookMS)
+	assert.Equal(t, uint64(2), r.HitCount)
+	assert.Equal(t, "eq", r.HitCountRelation)
+	assert.Len(t, r.Hits, 2)
+	assert.Equal(t, "logs-2023.12.01", r.Hits[0].Index)
+	assert.Equal(t, "", r.Hits[0].Type)
+	assert.Equal(t, "fatal", r.Hits[1].Source["severity"])
+}
+
+func TestDetectVersion(t *T) {
+	s, addr := testServer(200, rootOpenSearch2)
+	defer s.Close()
+
+	oldPool, oldVersion := pool, version
+	defer func() { pool, version = oldPool, oldVersion }()
+	pool = newNodePool([]string{addr}, 0)
+	version = ClusterVersion{}
+
+	v, err := DetectVersion()
+	require.Nil(t, err)
+	assert.Equal(t, FlavorOpenSearch, v.Flavor)
+	assert.Equal(t, v, Version())
+}
+
+func TestDetectVersionAPIKeyFallback(t *T) {
+	var l sync.Mutex
+	var auths []string
+	root := rootOpenSearch2
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.Lock()
+		defer l.Unlock()
+		auth := r.Header.Get("Authorization")
+		auths = append(auths, strings.SplitN(auth, " ", 2)[0])
+		if strings.HasPrefix(auth, "ApiKey ") {
+			w.WriteHeader(401)
+			return
+		}
+		fmt.Fprint(w, root)
+	}))
+	defer s.Close()
+
+	oldPool, oldVersion := pool, version
+	oldKey, oldUser := config.ElasticSearchAPIKey, config.ElasticSearchUser
+	defer func() {
+		pool, version, apiKeyRejected = oldPool, oldVersion, false
+		config.ElasticSearchAPIKey, config.ElasticSearchUser = oldKey, oldUser
+	}()
+	pool = newNodePool([]string{strings.TrimPrefix(s.URL, "http://")}, 0)
+	version = ClusterVersion{}
+	config.ElasticSearchAPIKey, config.ElasticSearchUser = "abc", "user"
+
+	v, err := DetectVersion()
+	require.Nil(t, err)
+	assert.Equal(t, FlavorOpenSearch, v.Flavor)
+
+	// if elasticsearch itself rejects the api key then basic auth is kept
+	// for later requests
+	l.Lock()
+	root = rootElasticsearch6
+	l.Unlock()
+	version = ClusterVersion{}
+	v, err = DetectVersion()
+	require.Nil(t, err)
+	assert.Equal(t, FlavorElasticsearch, v.Flavor)
+	code, _, err := pool.request("GET", "/", nil, true)
+	require.Nil(t, err)
+	assert.Equal(t, 200, code)
+
+	l.Lock()
+	assert.Equal(t, []string{"ApiKey", "Basic", "ApiKey", "Basic", "Basic"}, auths)
+	l.Unlock()
+
+	// without a user there's nothing to fall back on
+	version, apiKeyRejected = ClusterVersion{}, false
+	config.ElasticSearchUser = ""
+	_, err = DetectVersion()
+	assert.NotNil(t, err)
+}